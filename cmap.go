@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/404tk/cmap/options"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/config"
 	"github.com/404tk/cmap/sources/plugins"
 )
 
@@ -31,12 +33,60 @@ func New(opts *options.Options) (*Service, error) {
 	if opts.Timeout == 0 {
 		opts.Timeout = 30
 	}
+	if opts.QueryTimeout == 0 {
+		opts.QueryTimeout = 10 * time.Minute
+	}
 	if opts.RateLimit == 0 {
 		opts.RateLimit = 30
 	}
 	if opts.RateLimitUnit == 0 {
 		opts.RateLimitUnit = time.Minute
 	}
+	if opts.MaxResponseSize == 0 {
+		opts.MaxResponseSize = 100 << 20
+	}
+
+	retry := config.Retry()
+	if opts.Retry.MaxRetries == 0 {
+		opts.Retry.MaxRetries = retry.MaxRetries
+	}
+	if opts.Retry.MaxRetries == 0 {
+		opts.Retry.MaxRetries = 3
+	}
+	if opts.Retry.MinWait == 0 {
+		opts.Retry.MinWait = retry.MinWait
+	}
+	if opts.Retry.MinWait == 0 {
+		opts.Retry.MinWait = time.Second
+	}
+	if opts.Retry.MaxWait == 0 {
+		opts.Retry.MaxWait = retry.MaxWait
+	}
+	if opts.Retry.MaxWait == 0 {
+		opts.Retry.MaxWait = 30 * time.Second
+	}
+	if len(opts.KeyStrategy) == 0 {
+		opts.KeyStrategy = config.KeyStrategy()
+	}
+
+	// 未在选项中指定的代理、TLS配置使用配置文件中的值
+	opts.Transport = sources.MergeTransport(opts.Transport, config.Transport("global"))
+	if opts.EngineTransports == nil {
+		opts.EngineTransports = make(map[string]options.Transport)
+	}
+	for _, agent := range opts.Agents {
+		opts.EngineTransports[agent] = sources.MergeTransport(opts.EngineTransports[agent], config.Transport(agent))
+	}
+	opts.IconTransport = sources.MergeTransport(opts.IconTransport, config.Transport("icon"))
+
+	// 未在选项中指定的限制使用配置文件中的值
+	opts.Limits = mergeLimits(opts.Limits, config.Limits("global"))
+	if opts.EngineLimits == nil {
+		opts.EngineLimits = make(map[string]options.Limits)
+	}
+	for _, agent := range opts.Agents {
+		opts.EngineLimits[agent] = mergeLimits(opts.EngineLimits[agent], config.Limits(agent))
+	}
 
 	var err error
 	s.Session, err = sources.NewSession(opts)
@@ -46,6 +96,19 @@ func New(opts *options.Options) (*Service, error) {
 	return s, nil
 }
 
+func mergeLimits(l, fallback options.Limits) options.Limits {
+	if l.MaxResults == 0 {
+		l.MaxResults = fallback.MaxResults
+	}
+	if l.MaxPages == 0 {
+		l.MaxPages = fallback.MaxPages
+	}
+	if l.MaxQuota == 0 {
+		l.MaxQuota = fallback.MaxQuota
+	}
+	return l
+}
+
 func (s *Service) Execute(ctx context.Context) (<-chan sources.Result, error) {
 	// unlikely but as a precaution to handle random panics check all types
 	if err := s.nilCheck(); err != nil {
@@ -56,43 +119,112 @@ func (s *Service) Execute(ctx context.Context) (<-chan sources.Result, error) {
 		return nil, fmt.Errorf("no agent/source specified")
 	}
 
+	// 在发起任何请求前校验关键字，避免无效查询消耗额度
+	if k, ok := s.Options.Query.(plugins.Keyword); ok {
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+		// 原生查询只能由对应的引擎执行，引擎未启用时报错而不是忽略
+		for name := range k.Raw {
+			if !s.hasPlugin(name) {
+				return nil, fmt.Errorf("raw query for agent %s, which is not enabled", name)
+			}
+		}
+		s.Options.Query = k
+	}
+
 	megaChan := make(chan sources.Result, DefaultChannelBuffSize)
 	// iterate and run all sources
 	wg := &sync.WaitGroup{}
 	for _, plugin := range s.Plugins {
-		ch, err := plugin.Query(s.Session, s.Options.Query)
+		// 每个引擎单独限制查询总时长
+		pctx, cancel := context.WithTimeout(ctx, s.Options.QueryTimeout)
+		ch, err := plugin.Query(pctx, s.Session, s.Options.Query)
 		if err != nil {
+			cancel()
 			log.Printf("[%s] %v\n", plugin.Name(), err)
 			continue
 
 		}
 		wg.Add(1)
-		go func(source, relay chan sources.Result, ctx context.Context) {
+		go func(source <-chan sources.Result, cancel context.CancelFunc) {
 			defer wg.Done()
-			for {
+			defer cancel()
+			for res := range source {
+				res.Timestamp = time.Now().Unix()
 				select {
 				case <-ctx.Done():
-					return
-				case res, ok := <-source:
-					res.Timestamp = time.Now().Unix()
-					if !ok {
-						return
+					// 通知插件停止并丢弃剩余结果，保证插件协程能够退出
+					cancel()
+					for range source {
 					}
-					relay <- res
+					return
+				case megaChan <- res:
 				}
 			}
-		}(ch, megaChan, ctx)
+		}(ch, cancel)
 	}
 
 	// close channel when all sources return
-	go func(wg *sync.WaitGroup, megaChan chan sources.Result) {
+	go func() {
 		wg.Wait()
-		defer close(megaChan)
-	}(wg, megaChan)
+		close(megaChan)
+	}()
 
 	return megaChan, nil
 }
 
+// Plan lists the queries each agent would send without making billable calls
+func (s *Service) Plan(ctx context.Context) ([]sources.Plan, error) {
+	ch, err := s.Execute(sources.WithPlanning(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var plans []sources.Plan
+	for result := range ch {
+		switch {
+		case result.Plan != nil:
+			plan := *result.Plan
+			// 展示自定义接口地址
+			if u, err := url.Parse(plan.Endpoint); err == nil {
+				s.Session.Rebase(plan.Source, u)
+				plan.Endpoint = u.String()
+			}
+			plans = append(plans, plan)
+		case result.Error != nil:
+			plans = append(plans, sources.Plan{Source: result.Source, Error: result.Error})
+		}
+	}
+	return plans, nil
+}
+
+// Accounts queries account info and remaining quota of every configured key
+func (s *Service) Accounts(ctx context.Context) ([]sources.Account, error) {
+	if err := s.nilCheck(); err != nil {
+		return nil, err
+	}
+	// 各引擎并发查询，按引擎顺序返回
+	res := make([][]sources.Account, len(s.Plugins))
+	wg := &sync.WaitGroup{}
+	for i, plugin := range s.Plugins {
+		wg.Add(1)
+		go func(i int, plugin plugins.Plugin) {
+			defer wg.Done()
+			res[i] = plugin.AccountInfo(ctx, s.Session)
+		}(i, plugin)
+	}
+	wg.Wait()
+
+	var accounts []sources.Account
+	for _, a := range res {
+		for _, account := range a {
+			account.Error = sources.RedactError(account.Error)
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts, nil
+}
+
 // ExecuteWithWriters writes output to writer along with stdout
 func (s *Service) ExecuteWithCallback(ctx context.Context, callback func(result sources.Result)) error {
 	ch, err := s.Execute(ctx)
@@ -115,6 +247,14 @@ func (s *Service) ExecuteWithCallback(ctx context.Context, callback func(result
 	}
 }
 
+// Close releases the goroutines and connections held by the session,
+// call it after all results have been read
+func (s *Service) Close() {
+	if s.Session != nil {
+		s.Session.Close()
+	}
+}
+
 func (s *Service) nilCheck() error {
 	if s.Options == nil {
 		return fmt.Errorf("options cannot be nil")
@@ -124,3 +264,12 @@ func (s *Service) nilCheck() error {
 	}
 	return nil
 }
+
+func (s *Service) hasPlugin(name string) bool {
+	for _, plugin := range s.Plugins {
+		if plugin.Name() == name {
+			return true
+		}
+	}
+	return false
+}
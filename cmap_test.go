@@ -0,0 +1,168 @@
+package cmap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/projectdiscovery/ratelimit"
+	"go.uber.org/goleak"
+
+	"github.com/404tk/cmap/options"
+	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/plugins"
+)
+
+// newTestService 返回查询internetdb的Service，请求由handler处理，
+// 测试结束关闭Service后检查是否有协程泄露
+func newTestService(t *testing.T, ips []string, handler http.HandlerFunc) *Service {
+	t.Helper()
+	ignore := goleak.IgnoreCurrent()
+	t.Cleanup(func() { goleak.VerifyNone(t, ignore) })
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	s, err := New(&options.Options{
+		Agents:    []string{"internetdb"},
+		Query:     plugins.Keyword{IP: ips},
+		Endpoints: map[string]string{"internetdb": srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ports 返回包含n个端口的internetdb响应
+func ports(n int) string {
+	res := `{"ip":"10.0.0.1","ports":[`
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			res += ","
+		}
+		res += fmt.Sprint(i + 1)
+	}
+	return res + `]}`
+}
+
+// waitClosed 读取ch中剩余的结果，ch未在超时前关闭时测试失败
+func waitClosed(t *testing.T, ch <-chan sources.Result) {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("result channel not closed after cancel")
+		}
+	}
+}
+
+func TestExecuteFinish(t *testing.T) {
+	s := newTestService(t, []string{"10.0.0.1", "10.0.0.2"}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ports(3))
+	})
+	ch, err := s.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for result := range ch {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		count++
+	}
+	if count != 6 {
+		t.Fatalf("got %d results, want 6", count)
+	}
+}
+
+func TestExecuteCancelMidPage(t *testing.T) {
+	// 第一个IP立即返回，之后的请求阻塞到请求被取消
+	first := make(chan struct{}, 1)
+	first <- struct{}{}
+	s := newTestService(t, []string{"10.0.0.0/24"}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-first:
+			fmt.Fprint(w, ports(1))
+		default:
+			<-r.Context().Done()
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-ch; !ok {
+		t.Fatal("result channel closed before first result")
+	}
+	cancel()
+	waitClosed(t, ch)
+}
+
+func TestExecuteCancelBlockedOnSend(t *testing.T) {
+	// 结果数超过通道缓冲，不读取结果时插件阻塞在发送上
+	s := newTestService(t, []string{"10.0.0.1"}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ports(DefaultChannelBuffSize*4))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := s.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(3 * time.Second)
+	for len(ch) < cap(ch) {
+		select {
+		case <-timeout:
+			t.Fatal("result channel not filled")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	waitClosed(t, ch)
+}
+
+func TestExecuteCancelWaitingRateLimit(t *testing.T) {
+	// 每小时只允许一次请求，第二个IP阻塞在频率限制上
+	limit := sources.DefaultRateLimits["internetdb"]
+	sources.DefaultRateLimits["internetdb"] = &ratelimit.Options{Key: "internetdb", MaxCount: 1, Duration: time.Hour}
+	t.Cleanup(func() { sources.DefaultRateLimits["internetdb"] = limit })
+
+	s := newTestService(t, []string{"10.0.0.1", "10.0.0.2"}, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ports(1))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-ch; !ok {
+		t.Fatal("result channel closed before first result")
+	}
+	start := time.Now()
+	cancel()
+	waitClosed(t, ch)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("result channel closed %v after cancel", d)
+	}
+}
+
+func TestExecuteRawDisabledAgent(t *testing.T) {
+	s := newTestService(t, []string{"10.0.0.1"}, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s", r.URL)
+	})
+	s.Options.Query = plugins.Keyword{Raw: map[string][]string{"fofa": {`app="nginx"`}}}
+	if _, err := s.Execute(context.Background()); err == nil {
+		t.Fatal("Execute() succeeded with raw query for a disabled agent")
+	}
+}
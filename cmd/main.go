@@ -6,38 +6,85 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/404tk/cmap"
 	"github.com/404tk/cmap/cmd/excel"
 	"github.com/404tk/cmap/options"
+	"github.com/404tk/cmap/query"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
 	"github.com/404tk/cmap/sources/config"
+	"github.com/404tk/cmap/sources/icon"
 	"github.com/404tk/cmap/sources/plugins"
 	"github.com/404tk/cmap/utils"
 )
 
 var (
 	agent      string
-	ip         string
-	domain     string
-	md5_str    string
-	mmh3_str   string
-	cert       string
+	ips        arrayFlags
+	domains    arrayFlags
+	domainMode string
+	md5s       arrayFlags
+	mmh3s      arrayFlags
+	certs      arrayFlags
+	icons      arrayFlags
+	certFiles  arrayFlags
+	targets    string
+	expr       string
+	raws       arrayFlags
 	configPath string
 	output     string
+	history    bool
+	maxTime    int
+	dryRun     bool
+	limits     options.Limits
+	strategy   string
+	endpoints  arrayFlags
+	showStats  bool
 )
 
+// arrayFlags 可重复指定的参数
+type arrayFlags []string
+
+func (a *arrayFlags) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *arrayFlags) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
 func init() {
 	flag.StringVar(&agent, "agent", "fofa,quake,hunter,shodan", "Agent")
-	flag.StringVar(&ip, "ip", "", "IP")
-	flag.StringVar(&domain, "domain", "", "Domain")
-	flag.StringVar(&md5_str, "md5", "", "Favicon md5")
-	flag.StringVar(&mmh3_str, "mmh3", "", "Favicon mmh3")
-	flag.StringVar(&cert, "cert", "", "Certificate")
+	flag.Var(&ips, "ip", "IP or CIDR (repeatable)")
+	flag.Var(&domains, "domain", "Domain (repeatable)")
+	flag.StringVar(&domainMode, "domain-mode", "sub", "Domain match mode: sub (with subdomains), exact, root (root domain with subdomains)")
+	flag.Var(&md5s, "md5", "Favicon md5 (repeatable)")
+	flag.Var(&mmh3s, "mmh3", "Favicon mmh3 (repeatable)")
+	flag.Var(&icons, "icon", "Favicon file or URL, computes both md5 and mmh3 (repeatable)")
+	flag.Var(&certs, "cert", "Certificate (repeatable)")
+	flag.Var(&certFiles, "cert-file", "PEM/DER certificate file or TLS endpoint host:port (repeatable)")
+	flag.StringVar(&targets, "iL", "", "Target list file, one target per line (icon:<hash> for favicon mmh3), - for stdin")
+	flag.StringVar(&expr, "q", "", `Query expression, e.g. title:"login" AND (port:80 OR port:443)`)
+	flag.Var(&raws, "raw", `Native query of an agent, e.g. 'fofa:app="Apache-Tomcat" && country="CN"' (repeatable)`)
 	flag.StringVar(&configPath, "config", "config.yaml", "config file path")
 	flag.StringVar(&output, "oX", "", "output filename")
+	flag.BoolVar(&history, "history", false, "Include historical banners (shodan)")
+	flag.IntVar(&maxTime, "max-time", 10, "Max query time of each agent in minutes")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print translated queries and estimated cost without querying")
+	flag.IntVar(&limits.MaxResults, "max-results", 0, "Max results of the whole run, 0 for unlimited")
+	flag.IntVar(&limits.MaxPages, "max-pages", 0, "Max pages requested in the whole run, 0 for unlimited")
+	flag.IntVar(&limits.MaxQuota, "max-quota", 0, "Max quota spent in the whole run, 0 for unlimited")
+	flag.StringVar(&strategy, "key-strategy", "", "Key rotation strategy: random, round-robin, least-used (default from config)")
+	flag.Var(&endpoints, "endpoint", "Custom base URL of an agent, e.g. fofa=https://fofa.example.com (repeatable)")
+	flag.BoolVar(&showStats, "stats", false, "Print request, connection and latency stats of each agent")
 	flag.Parse()
 
 	if len(output) == 0 {
@@ -46,31 +93,122 @@ func init() {
 }
 
 func main() {
+	log.SetOutput(sources.NewRedactWriter(os.Stderr))
+	if flag.Arg(0) == "account" {
+		printAccounts(flag.Args()[1:])
+		return
+	}
 	config.InitConfig(configPath)
+	var exprs []query.Node
+	if len(expr) > 0 {
+		node, err := query.Parse(expr)
+		if err != nil {
+			log.Fatalf("查询语句解析失败: %v\n", err)
+		}
+		exprs = append(exprs, node)
+	}
+	rawMap := make(map[string][]string)
+	for _, raw := range raws {
+		name, q, ok := strings.Cut(raw, ":")
+		if _, exist := plugins.Plugins[name]; !ok || !exist || len(q) == 0 {
+			log.Fatalf("原生查询语句格式错误: %s\n", raw)
+		}
+		rawMap[name] = append(rawMap[name], q)
+	}
+	mode, ok := plugins.ParseDomainMatch(domainMode)
+	if !ok {
+		log.Fatalf("域名匹配方式错误: %s\n", domainMode)
+	}
+	keyword := plugins.Keyword{
+		IP:         ips,
+		Domain:     domains,
+		DomainMode: mode,
+		Cert:       certs,
+		Expr:       exprs,
+		Raw:        rawMap,
+		History:    history,
+	}
+	for _, md5 := range md5s {
+		keyword.Icon = append(keyword.Icon, plugins.Icon{Md5: md5})
+	}
+	for _, mmh3 := range mmh3s {
+		keyword.Icon = append(keyword.Icon, plugins.Icon{Mmh3: mmh3})
+	}
+	// 命令行未指定任何目标时才读取标准输入，避免在循环中调用时读走循环的输入
+	if len(targets) == 0 && !hasTargetFlags() && hasStdin() {
+		targets = "-"
+	}
+	if len(targets) > 0 {
+		if err := loadTargets(&keyword, targets); err != nil {
+			log.Fatalf("读取目标列表失败: %v\n", err)
+		}
+	}
+	endpointMap := make(map[string]string)
+	for _, e := range endpoints {
+		name, u, ok := strings.Cut(e, "=")
+		if !ok || len(u) == 0 {
+			log.Fatalf("接口地址格式错误: %s\n", e)
+		}
+		endpointMap[name] = u
+	}
 	opts := &options.Options{
-		Agents: strings.Split(agent, ","),
-		Query: plugins.Keyword{
-			IP:     []string{ip},
-			Domain: []string{domain},
-			Icon: []struct {
-				Md5  string
-				Mmh3 string
-			}{{md5_str, mmh3_str}},
-			Cert: []string{cert},
-		},
-		Timeout: 20,
+		Agents:       strings.Split(agent, ","),
+		Query:        keyword,
+		Timeout:      20,
+		QueryTimeout: time.Duration(maxTime) * time.Minute,
+		Limits:       limits,
+		KeyStrategy:  strategy,
+		Endpoints:    endpointMap,
 	}
 
 	u, err := cmap.New(opts)
 	if err != nil {
-		panic(err)
+		log.Fatalf("初始化失败: %v\n", sources.RedactError(err))
+	}
+	defer u.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// 计算图标hash，同时补全各引擎所需的md5及mmh3
+	for _, target := range icons {
+		data, err := icon.Load(ctx, u.Session, target)
+		if err != nil {
+			log.Fatalf("读取图标失败: %v\n", err)
+		}
+		md5sum, mmh3 := icon.Hash(data)
+		fmt.Printf("[icon] %s md5:%s mmh3:%s\n", target, md5sum, mmh3)
+		keyword.Icon = append(keyword.Icon, plugins.Icon{Md5: md5sum, Mmh3: mmh3})
+	}
+
+	// 提取证书序列号及指纹，用于各引擎的精确查询
+	for _, target := range certFiles {
+		info, err := cert.Load(ctx, u.Session, target)
+		if err != nil {
+			log.Fatalf("读取证书失败: %v\n", err)
+		}
+		fmt.Printf("[cert] %s %s\n", target, info)
+		keyword.CertInfo = append(keyword.CertInfo, info)
+	}
+	opts.Query = keyword
+
+	if dryRun {
+		printPlan(ctx, u)
+		return
 	}
 
 	hashMap := make(map[string]int)
 	ipMap := make(map[string]ipDetail)
+	domainSet := utils.NewStringSet()
 	result := func(result sources.Result) {
 		if result.Error != nil {
 			fmt.Printf("[%s] %v\n", result.Source, result.Error)
+		} else if result.Account != nil {
+			fmt.Printf("[%s] %s 剩余额度: %s\n", result.Source, result.Prompt, remaining(result.Account.Remaining))
+		} else if len(result.IP) == 0 {
+			// 证书透明度等来源仅返回域名，不含IP和端口
+			domainSet.AddAll(result.Host)
+			fmt.Printf("[%s] %s\n", result.Source, strings.Join(result.Host, ","))
 		} else {
 			// 基于IP、端口生成唯一hash进行去重
 			index := generateHash(fmt.Sprintf("%s_%s", result.IP, result.Port))
@@ -97,10 +235,111 @@ func main() {
 	// ch , err := u.Execute(context.Background())
 
 	// Execute with Callback calls u.Execute() internally and abstracts channel handling logic
-	if err := u.ExecuteWithCallback(context.TODO(), result); err != nil {
-		panic(err)
+	if err := u.ExecuteWithCallback(ctx, result); err != nil {
+		// 关键字校验失败时每行输出一个无效关键字
+		log.Fatalf("查询失败:\n%v\n", err)
+	}
+	if showStats {
+		printStats(u)
 	}
-	excelExport(ipMap)
+	excelExport(ipMap, domainSet)
+}
+
+func printStats(u *cmap.Service) {
+	stats := u.Session.Stats()
+	var names []string
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := stats[name]
+		fmt.Printf("[%s] requests: %d\tretries: %d\terrors: %d\tconns: %d new, %d reused\tbytes: %d\tavg latency: %v\tdisabled keys: %d\n",
+			name, st.Requests, st.Retries, st.Errors, st.NewConns, st.ReusedConns, st.Bytes, st.AvgLatency(), st.Quarantined)
+	}
+}
+
+func printPlan(ctx context.Context, u *cmap.Service) {
+	plans, err := u.Plan(ctx)
+	if err != nil {
+		log.Fatalf("查询失败:\n%v\n", err)
+	}
+	unknown := func(n int) string {
+		if n < 0 {
+			return "?"
+		}
+		return fmt.Sprint(n)
+	}
+	for _, p := range plans {
+		if p.Error != nil {
+			fmt.Printf("[%s] %v\n", p.Source, p.Error)
+			continue
+		}
+		fmt.Printf("[%s] %s\n\tendpoint: %s\n\ttotal: %s\tpages: %s\tcost: %s\n",
+			p.Source, p.Query, p.Endpoint, unknown(p.Total), unknown(p.Pages), unknown(p.Cost))
+	}
+}
+
+// printAccounts 查询配置文件中全部key的账户信息，未指定-agent时查询所有引擎。
+// 子命令之后的参数单独解析，-agent及-config可在account前后指定
+func printAccounts(args []string) {
+	explicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "agent" {
+			explicit = true
+		}
+	})
+	fs := flag.NewFlagSet("account", flag.ExitOnError)
+	accountAgent := fs.String("agent", "", "Agent (default all agents)")
+	fs.StringVar(&configPath, "config", configPath, "config file path")
+	fs.Parse(args)
+	if len(*accountAgent) > 0 {
+		agent, explicit = *accountAgent, true
+	}
+	config.InitConfig(configPath)
+
+	agents := strings.Split(agent, ",")
+	if !explicit {
+		agents = agents[:0]
+		for name := range plugins.Plugins {
+			agents = append(agents, name)
+		}
+		sort.Strings(agents)
+	}
+	u, err := cmap.New(&options.Options{Agents: agents, Timeout: 20})
+	if err != nil {
+		log.Fatalf("初始化失败: %v\n", sources.RedactError(err))
+	}
+	defer u.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	accounts, err := u.Accounts(ctx)
+	if err != nil {
+		log.Fatalf("查询账户信息失败: %v\n", sources.RedactError(err))
+	}
+	if len(accounts) == 0 {
+		fmt.Println("配置文件中未配置任何key")
+	}
+	for _, a := range accounts {
+		if a.Error != nil {
+			fmt.Printf("[%s] %s %v\n", a.Source, a.Key, a.Error)
+			continue
+		}
+		expiry := a.Expiry
+		if len(expiry) == 0 {
+			expiry = "?"
+		}
+		fmt.Printf("[%s] %s\n\tplan: %s\tremaining: %s\texpiry: %s\n",
+			a.Source, a.Key, a.Plan, remaining(a.Remaining), expiry)
+	}
+}
+
+func remaining(n int) string {
+	if n < 0 {
+		return "?"
+	}
+	return fmt.Sprint(n)
 }
 
 type ipDetail struct {
@@ -108,7 +347,7 @@ type ipDetail struct {
 	Hosts utils.StringSet
 }
 
-func excelExport(data map[string]ipDetail) {
+func excelExport(data map[string]ipDetail, domains utils.StringSet) {
 	if !strings.HasSuffix(output, ".xlsx") {
 		fmt.Println("导出文件仅支持.xlsx格式！")
 		return
@@ -125,6 +364,13 @@ func excelExport(data map[string]ipDetail) {
 	for ip, d := range data {
 		portMap[ip] = d.Ports.AsArray()
 		hostMap[ip] = sources.IpDomainArray(ip, d.Hosts.AsArray())
+		for _, host := range d.Hosts.AsArray() {
+			delete(domains, host)
+		}
+	}
+	if len(domains) > 0 {
+		// 未解析到IP的域名单独归为一组
+		hostMap[""] = sources.IpDomainArray("", domains.AsArray())
 	}
 
 	e.F.SetSheetName("Sheet1", "端口服务")
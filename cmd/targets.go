@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/404tk/cmap/sources/plugins"
+)
+
+// loadTargets 从文件读取目标列表，每行一个目标，"-" 表示标准输入
+func loadTargets(k *plugins.Keyword, filename string) error {
+	var r io.Reader
+	if filename == "-" {
+		r = os.Stdin
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// 返回所有无法识别的行，避免部分目标被静默忽略
+	var errs []error
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		// 忽略空行及注释
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := k.Add(line); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", n, err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// hasTargetFlags 判断命令行中是否指定了查询目标
+func hasTargetFlags() bool {
+	return len(ips) > 0 || len(domains) > 0 || len(md5s) > 0 || len(mmh3s) > 0 || len(icons) > 0 ||
+		len(certs) > 0 || len(certFiles) > 0 || len(expr) > 0 || len(raws) > 0
+}
+
+// hasStdin 判断标准输入是否为管道或重定向
+func hasStdin() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode().IsRegular() || stat.Mode()&os.ModeNamedPipe != 0
+}
@@ -6,6 +6,9 @@ require (
 	github.com/projectdiscovery/ratelimit v0.0.55
 	github.com/spf13/viper v1.19.0
 	github.com/xuri/excelize/v2 v2.8.1
+	go.uber.org/goleak v1.3.0
+	golang.org/x/net v0.23.0
+	golang.org/x/time v0.5.0
 )
 
 require (
@@ -30,10 +33,8 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
-	golang.org/x/net v0.23.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -8,8 +8,64 @@ type Options struct {
 	Agents  []string
 	Query   interface{}
 	Timeout int // default 30s
+	// QueryTimeout limits the total query time of each agent
+	QueryTimeout time.Duration // default 10 minutes
 	// Note these ratelimits are used as fallback in case agent
 	// ratelimit is not available in DefaultRateLimits
 	RateLimit     uint          // default 30 req
 	RateLimitUnit time.Duration // default unit
+	// Limits applies to the whole run across all agents,
+	// EngineLimits applies to each agent separately
+	Limits       Limits
+	EngineLimits map[string]Limits
+	// KeyStrategy selects the next key of an agent:
+	// random, round-robin or least-used, default random
+	KeyStrategy string
+	// Retry controls retries of failed requests
+	Retry Retry
+	// Endpoints overrides the base URL of agents, including scheme,
+	// host and optional path prefix, e.g. https://fofa.example.com/prefix
+	Endpoints map[string]string
+	// Transport applies to all agents, EngineTransports overrides
+	// it for each agent, unset fields fall back to Transport
+	Transport        Transport
+	EngineTransports map[string]Transport
+	// IconTransport applies to favicon and certificate downloads from
+	// targets. TLS verification stays on, ignoring the global setting,
+	// unless Insecure is explicitly set to true
+	IconTransport Transport
+	// MaxResponseSize caps the body of a single response in bytes
+	MaxResponseSize int64 // default 100MB
+}
+
+// Transport configures the HTTP client of an agent
+type Transport struct {
+	// Proxy is a http, https or socks5 proxy URL, empty uses the
+	// environment proxy and "direct" disables proxying
+	Proxy string `mapstructure:"proxy"`
+	// Insecure disables TLS certificate verification, nil falls back
+	// to the global setting so an agent can turn verification back on
+	Insecure *bool `mapstructure:"insecure"`
+	// CA is a PEM bundle trusted in addition to the system roots
+	CA string `mapstructure:"ca"`
+	// ClientCert and ClientKey are PEM files of a client certificate
+	ClientCert string            `mapstructure:"client_cert"`
+	ClientKey  string            `mapstructure:"client_key"`
+	UserAgent  string            `mapstructure:"user_agent"`
+	Headers    map[string]string `mapstructure:"headers"`
+}
+
+// Retry is the retry policy of requests, waits grow exponentially
+// from MinWait up to MaxWait, MaxRetries -1 disables retries
+type Retry struct {
+	MaxRetries int           `mapstructure:"max_retries"` // default 3
+	MinWait    time.Duration `mapstructure:"min_wait"`    // default 1s
+	MaxWait    time.Duration `mapstructure:"max_wait"`    // default 30s
+}
+
+// Limits caps a query run, zero means unlimited
+type Limits struct {
+	MaxResults int `mapstructure:"max_results"`
+	MaxPages   int `mapstructure:"max_pages"`
+	MaxQuota   int `mapstructure:"max_quota"`
 }
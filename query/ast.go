@@ -0,0 +1,67 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fields 查询语法支持的字段
+var Fields = []string{"ip", "domain", "port", "title", "body", "header", "cert", "icon", "org", "asn", "country"}
+
+// Node 查询语法树节点
+type Node interface {
+	String() string
+}
+
+// Clause 单个字段条件，例如 title:"login"
+type Clause struct {
+	Field string
+	Value string
+}
+
+func (c *Clause) String() string {
+	return fmt.Sprintf("%s:%q", c.Field, c.Value)
+}
+
+// And 所有子条件均需满足
+type And struct {
+	Nodes []Node
+}
+
+func (a *And) String() string {
+	return join(a.Nodes, " AND ")
+}
+
+// Or 任一子条件满足即可
+type Or struct {
+	Nodes []Node
+}
+
+func (o *Or) String() string {
+	return join(o.Nodes, " OR ")
+}
+
+// Not 子条件取反
+type Not struct {
+	Node Node
+}
+
+func (n *Not) String() string {
+	if _, ok := n.Node.(*Clause); ok {
+		return "NOT " + n.Node.String()
+	}
+	return "NOT (" + n.Node.String() + ")"
+}
+
+func join(nodes []Node, sep string) string {
+	var parts []string
+	for _, node := range nodes {
+		s := node.String()
+		switch node.(type) {
+		case *And, *Or:
+			s = "(" + s + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,308 @@
+package query
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+	tokenClause
+)
+
+type token struct {
+	kind  tokenKind
+	field string
+	value string
+	pos   int
+}
+
+// Parse 解析查询语句，例如：
+//
+//	title:"后台管理" AND (port:8080 OR port:8443) AND NOT country:CN
+//
+// 运算符支持 AND/&&、OR/||、NOT/!，相邻条件之间省略运算符时视为 AND。
+func Parse(s string) (Node, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected token at position %d", t.pos)
+	}
+	return node, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (Node, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{node}
+	for p.peek().kind == tokenOr {
+		p.next()
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return &Or{Nodes: nodes}, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{node}
+	for {
+		switch p.peek().kind {
+		case tokenAnd:
+			p.next()
+		case tokenNot, tokenLParen, tokenClause:
+			// 省略运算符视为AND
+		default:
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return &And{Nodes: nodes}, nil
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Node: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenClause:
+		return &Clause{Field: t.field, Value: t.value}, nil
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, fmt.Errorf("missing ')' at position %d", t.pos)
+		}
+		return node, nil
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+	default:
+		return nil, fmt.Errorf("unexpected token at position %d", t.pos)
+	}
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	r := []rune(s)
+	i := 0
+	for i < len(r) {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: i})
+			i++
+		case c == '!':
+			tokens = append(tokens, token{kind: tokenNot, pos: i})
+			i++
+		case c == '&' || c == '|':
+			if i+1 >= len(r) || r[i+1] != c {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			kind := tokenAnd
+			if c == '|' {
+				kind = tokenOr
+			}
+			tokens = append(tokens, token{kind: kind, pos: i})
+			i += 2
+		default:
+			start := i
+			for i < len(r) && isWordRune(r[i]) {
+				i++
+			}
+			word := string(r[start:i])
+			if len(word) == 0 {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			if i < len(r) && (r[i] == ':' || r[i] == '=') {
+				field := strings.ToLower(word)
+				if !isField(field) {
+					return nil, fmt.Errorf("unknown field %q at position %d", word, start)
+				}
+				i++
+				value, n, err := lexValue(r[i:])
+				if err != nil {
+					return nil, fmt.Errorf("%v at position %d", err, i)
+				}
+				value, err = normalize(field, value)
+				if err != nil {
+					return nil, fmt.Errorf("%v at position %d", err, start)
+				}
+				i += n
+				tokens = append(tokens, token{kind: tokenClause, field: field, value: value, pos: start})
+				continue
+			}
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, token{kind: tokenAnd, pos: start})
+			case "OR":
+				tokens = append(tokens, token{kind: tokenOr, pos: start})
+			case "NOT":
+				tokens = append(tokens, token{kind: tokenNot, pos: start})
+			default:
+				return nil, fmt.Errorf("expected field:value at position %d, got %q", start, word)
+			}
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, pos: len(r)})
+	return tokens, nil
+}
+
+// lexValue 读取字段值，支持双引号包裹及\"转义，返回值和读取的字符数
+func lexValue(r []rune) (string, int, error) {
+	if len(r) > 0 && r[0] == '"' {
+		var sb strings.Builder
+		for i := 1; i < len(r); i++ {
+			switch r[i] {
+			case '\\':
+				if i+1 < len(r) {
+					i++
+					sb.WriteRune(r[i])
+				}
+			case '"':
+				return sb.String(), i + 1, nil
+			default:
+				sb.WriteRune(r[i])
+			}
+		}
+		return "", 0, fmt.Errorf("unterminated string")
+	}
+	i := 0
+	for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != '(' && r[i] != ')' {
+		i++
+	}
+	if i == 0 {
+		return "", 0, fmt.Errorf("empty value")
+	}
+	return string(r[:i]), i, nil
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+func isField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	portRegexp   = regexp.MustCompile(`^[0-9]{1,5}$`)
+	asnRegexp    = regexp.MustCompile(`^(?i:AS)?[0-9]{1,10}$`)
+	mmh3Regexp   = regexp.MustCompile(`^-?[0-9]{1,10}$`)
+	domainRegexp = regexp.MustCompile(`^([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z][a-zA-Z0-9-]{0,61}[a-zA-Z0-9]$`)
+)
+
+// NormalizeDomain 将域名（含IDN）转换为小写punycode形式并校验格式
+func NormalizeDomain(domain string) (string, bool) {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil || !domainRegexp.MatchString(ascii) {
+		return "", false
+	}
+	return strings.ToLower(ascii), true
+}
+
+// normalize 校验有固定格式的字段值，避免无效查询消耗额度，域名统一转换为punycode
+func normalize(field, value string) (string, error) {
+	switch field {
+	case "ip":
+		if net.ParseIP(value) == nil {
+			if _, _, err := net.ParseCIDR(value); err != nil {
+				return "", fmt.Errorf("invalid ip %q", value)
+			}
+		}
+	case "port":
+		if port, err := strconv.Atoi(value); !portRegexp.MatchString(value) || err != nil || port > 65535 {
+			return "", fmt.Errorf("invalid port %q", value)
+		}
+	case "asn":
+		if !asnRegexp.MatchString(value) {
+			return "", fmt.Errorf("invalid asn %q", value)
+		}
+	case "domain":
+		ascii, ok := NormalizeDomain(value)
+		if !ok {
+			return "", fmt.Errorf("invalid domain %q", value)
+		}
+		return ascii, nil
+	case "icon":
+		if !md5Regexp.MatchString(value) && !mmh3Regexp.MatchString(value) {
+			return "", fmt.Errorf("invalid icon hash %q", value)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,81 @@
+package query
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"clause", `title:login`, `title:"login"`},
+		{"equal sign", `port=8080`, `port:"8080"`},
+		{"field case", `TITLE:login`, `title:"login"`},
+		{"implicit and", `title:login port:443`, `title:"login" AND port:"443"`},
+		{"and before or", `title:a OR title:b AND port:80`, `title:"a" OR (title:"b" AND port:"80")`},
+		{"and before or symbols", `title:a && port:80 || title:b`, `(title:"a" AND port:"80") OR title:"b"`},
+		{"group", `title:a AND (port:80 OR port:443)`, `title:"a" AND (port:"80" OR port:"443")`},
+		{"not binds tighter", `NOT country:CN AND port:80`, `NOT country:"CN" AND port:"80"`},
+		{"not group", `!(port:80 || port:443)`, `NOT (port:"80" OR port:"443")`},
+		{"double not", `NOT NOT port:80`, `NOT (NOT port:"80")`},
+		{"quoted", `title:"后台 管理"`, `title:"后台 管理"`},
+		{"escaped quote", `title:"a \"b\" \\c"`, `title:"a \"b\" \\c"`},
+		{"cidr", `ip:10.0.0.0/8`, `ip:"10.0.0.0/8"`},
+		{"asn prefix", `asn:AS4134`, `asn:"AS4134"`},
+		{"icon md5", `icon:d41d8cd98f00b204e9800998ecf8427e`, `icon:"d41d8cd98f00b204e9800998ecf8427e"`},
+		{"icon mmh3", `icon:-1234567`, `icon:"-1234567"`},
+		{"domain lower", `domain:Example.COM`, `domain:"example.com"`},
+		{"domain trailing dot", `domain:example.com.`, `domain:"example.com"`},
+		{"domain idn", `domain:例子.测试`, `domain:"xn--fsqu00a.xn--0zwm56d"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.input, err)
+			}
+			if got := node.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", ``, "unexpected end of query"},
+		{"unknown field", `foo:bar`, `unknown field "foo"`},
+		{"bare word", `login`, "expected field:value"},
+		{"missing paren", `(port:80`, "missing ')'"},
+		{"extra paren", `port:80)`, "unexpected token"},
+		{"dangling and", `port:80 AND`, "unexpected end of query"},
+		{"single ampersand", `port:80 & port:443`, `unexpected '&'`},
+		{"unterminated string", `title:"login`, "unterminated string"},
+		{"empty value", `title: port:80`, "empty value"},
+		{"invalid ip", `ip:1.2.3`, `invalid ip "1.2.3"`},
+		{"invalid port", `port:65536`, `invalid port "65536"`},
+		{"invalid asn", `asn:ASX`, `invalid asn "ASX"`},
+		{"invalid icon", `icon:abc`, `invalid icon hash "abc"`},
+		{"invalid domain", `domain:exa_mple..com`, `invalid domain "exa_mple..com"`},
+		{"domain without tld", `domain:localhost`, `invalid domain "localhost"`},
+		{"domain url", `domain:"https://example.com"`, `invalid domain "https://example.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := Parse(tt.input)
+			if err == nil {
+				t.Fatalf("Parse(%q) = %s, want error", tt.input, node)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse(%q) error = %v, want %q", tt.input, err, tt.want)
+			}
+		})
+	}
+}
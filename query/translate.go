@@ -0,0 +1,178 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var md5Regexp = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// Dialect 描述引擎的查询语法
+type Dialect struct {
+	// Fields 字段对应的查询模板，未列出的字段视为不支持；
+	// icon字段根据值的格式对应 icon.md5 或 icon.mmh3，值为CIDR的ip字段对应 ip.cidr，
+	// 未配置 ip.cidr 时使用 ip 的模板
+	Fields map[string]string
+	// NotFields 字段取反时使用的模板，例如 ip!="%s"
+	NotFields map[string]string
+	And       string
+	// AndNot 取反条件作为AND子条件时使用的连接符，例如 zoomeye 的 " -"
+	AndNot string
+	Or     string // 为空表示不支持
+	Not    string // 取反前缀，为空时仅支持 NotFields 中的字段取反
+	// NoGroup 引擎不支持括号分组
+	NoGroup bool
+	// Escape 转义字段值中的特殊字符，为空时不转义
+	Escape func(string) string
+}
+
+// EscapeValue 按引擎规则转义字段值
+func (d *Dialect) EscapeValue(s string) string {
+	if d.Escape == nil {
+		return s
+	}
+	return d.Escape(s)
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// QuoteEscape 转义反斜杠及双引号，适用于支持 \" 转义的引擎
+func QuoteEscape(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+var quoteStripper = strings.NewReplacer(`\`, "", `"`, "")
+
+// StripQuotes 删除双引号及反斜杠，适用于不支持转义的引擎
+func StripQuotes(s string) string {
+	return quoteStripper.Replace(s)
+}
+
+// UnsupportedError 记录引擎无法表达的条件
+type UnsupportedError struct {
+	Clauses []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported clauses: %s", strings.Join(e.Clauses, ", "))
+}
+
+// Translate 将语法树转换为引擎查询语句，存在无法表达的条件时返回 *UnsupportedError
+func (d *Dialect) Translate(node Node) (string, error) {
+	t := &translator{dialect: d}
+	s := t.translate(node, false)
+	if len(t.unsupported) > 0 {
+		return "", &UnsupportedError{Clauses: t.unsupported}
+	}
+	return s, nil
+}
+
+type translator struct {
+	dialect     *Dialect
+	unsupported []string
+}
+
+func (t *translator) translate(node Node, nested bool) string {
+	switch n := node.(type) {
+	case *Clause:
+		tpl, ok := lookup(t.dialect.Fields, n)
+		if !ok {
+			t.unsupported = append(t.unsupported, n.String())
+			return ""
+		}
+		return fmt.Sprintf(tpl, t.dialect.EscapeValue(n.Value))
+	case *Not:
+		if c, ok := n.Node.(*Clause); ok {
+			if tpl, ok := lookup(t.dialect.NotFields, c); ok {
+				return fmt.Sprintf(tpl, t.dialect.EscapeValue(c.Value))
+			}
+		}
+		if len(t.dialect.Not) == 0 {
+			t.unsupported = append(t.unsupported, n.String())
+			return ""
+		}
+		return t.dialect.Not + t.translate(n.Node, true)
+	case *And:
+		if len(t.dialect.AndNot) > 0 {
+			return t.andNot(n, nested)
+		}
+		return t.group(n.Nodes, t.dialect.And, nested, n)
+	case *Or:
+		if len(t.dialect.Or) == 0 {
+			t.unsupported = append(t.unsupported, n.String())
+			return ""
+		}
+		return t.group(n.Nodes, t.dialect.Or, nested, n)
+	}
+	return ""
+}
+
+func (t *translator) group(nodes []Node, sep string, nested bool, node Node) string {
+	var parts []string
+	for _, n := range nodes {
+		// 相同运算符的子节点无需分组
+		same := fmt.Sprintf("%T", n) == fmt.Sprintf("%T", node)
+		parts = append(parts, t.translate(n, !same))
+	}
+	s := strings.Join(parts, sep)
+	if !nested {
+		return s
+	}
+	if t.dialect.NoGroup {
+		t.unsupported = append(t.unsupported, "("+node.String()+")")
+		return ""
+	}
+	return "(" + s + ")"
+}
+
+func (t *translator) andNot(node *And, nested bool) string {
+	var sb strings.Builder
+	for i, n := range node.Nodes {
+		if not, ok := n.(*Not); ok {
+			sb.WriteString(t.dialect.AndNot)
+			sb.WriteString(t.translate(not.Node, true))
+			continue
+		}
+		if i > 0 {
+			sb.WriteString(t.dialect.And)
+		}
+		_, same := n.(*And)
+		sb.WriteString(t.translate(n, !same))
+	}
+	s := strings.TrimSpace(sb.String())
+	if !nested {
+		return s
+	}
+	if t.dialect.NoGroup {
+		t.unsupported = append(t.unsupported, "("+node.String()+")")
+		return ""
+	}
+	return "(" + s + ")"
+}
+
+// lookup 返回条件对应的模板，ip.cidr 未配置时回退到 ip
+func lookup(fields map[string]string, c *Clause) (string, bool) {
+	key := fieldKey(c)
+	if tpl, ok := fields[key]; ok {
+		return tpl, true
+	}
+	if key == "ip.cidr" {
+		tpl, ok := fields["ip"]
+		return tpl, ok
+	}
+	return "", false
+}
+
+func fieldKey(c *Clause) string {
+	if c.Field == "ip" && strings.Contains(c.Value, "/") {
+		return "ip.cidr"
+	}
+	if c.Field != "icon" {
+		return c.Field
+	}
+	if md5Regexp.MatchString(c.Value) {
+		return "icon.md5"
+	}
+	return "icon.mmh3"
+}
@@ -0,0 +1,11 @@
+package sources
+
+// Account 描述一个key的账户信息及剩余额度
+type Account struct {
+	Source    string
+	Key       string // key标识，如邮箱、censys的API ID或key池中的编号(hunter#0)，不含key本身
+	Plan      string // 会员等级或订阅计划
+	Remaining int    // 剩余查询额度，-1表示未知
+	Expiry    string // 到期或额度重置时间，空表示未知
+	Error     error
+}
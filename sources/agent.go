@@ -1,6 +1,8 @@
 package sources
 
+import "context"
+
 type Agent interface {
-	Query(*Session, string) (chan Result, error)
+	Query(context.Context, *Session, string) (chan Result, error)
 	Name() string
 }
@@ -0,0 +1,57 @@
+package sources
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 丢弃剩余响应体的上限，超出时直接关闭连接
+const maxDrainSize = 256 << 10
+
+// ErrResponseTooLarge 响应体超过MaxResponseSize
+var ErrResponseTooLarge = errors.New("response too large")
+
+// DrainBody 读取并丢弃剩余响应体后关闭，使连接可以复用
+func DrainBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainSize))
+	body.Close()
+}
+
+// limitedBody 限制响应体大小并统计读取的字节数，关闭时丢弃剩余内容
+type limitedBody struct {
+	body   io.ReadCloser
+	limit  int64
+	read   int64
+	closed bool
+	onDone func(read int64)
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limit > 0 && b.read >= b.limit {
+		return 0, fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, b.limit)
+	}
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read {
+		p = p[:b.limit-b.read+1]
+	}
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		return n, fmt.Errorf("%w: exceeds %d bytes", ErrResponseTooLarge, b.limit)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.onDone(b.read)
+	// 超出大小限制时不再读取剩余内容
+	if b.limit > 0 && b.read > b.limit {
+		return b.body.Close()
+	}
+	DrainBody(b.body)
+	return nil
+}
@@ -0,0 +1,116 @@
+package sources
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/404tk/cmap/options"
+)
+
+// ErrLimitReached 查询达到结果数、页数或额度限制
+var ErrLimitReached = errors.New("limit reached")
+
+// Budget 记录一次运行中的结果数、页数及额度消耗，parent为全局限制
+type Budget struct {
+	mu      sync.Mutex
+	name    string
+	limits  options.Limits
+	results int
+	pages   int
+	quota   int
+	parent  *Budget
+}
+
+func NewBudget(name string, limits options.Limits, parent *Budget) *Budget {
+	return &Budget{name: name, limits: limits, parent: parent}
+}
+
+// TakePage 申请请求一页数据，超出页数、结果数或额度限制时返回错误
+func (b *Budget) TakePage() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkLocked(); err != nil {
+		return err
+	}
+	if b.limits.MaxPages > 0 && b.pages >= b.limits.MaxPages {
+		return fmt.Errorf("%w: %s max pages %d", ErrLimitReached, b.name, b.limits.MaxPages)
+	}
+	if err := b.parent.TakePage(); err != nil {
+		return err
+	}
+	b.pages++
+	return nil
+}
+
+// TakeResult 申请输出一条结果，超出结果数限制时返回错误
+func (b *Budget) TakeResult() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxResults > 0 && b.results >= b.limits.MaxResults {
+		return fmt.Errorf("%w: %s max results %d", ErrLimitReached, b.name, b.limits.MaxResults)
+	}
+	if err := b.parent.TakeResult(); err != nil {
+		return err
+	}
+	b.results++
+	return nil
+}
+
+// Spend 记录已消耗的额度
+func (b *Budget) Spend(quota int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.quota += quota
+	b.mu.Unlock()
+	b.parent.Spend(quota)
+}
+
+// Headroom 返回剩余可输出的结果数及可消耗的额度，取本级与全局限制中较小的值，-1表示不限制
+func (b *Budget) Headroom() (results, quota int) {
+	if b == nil {
+		return -1, -1
+	}
+	b.mu.Lock()
+	results = remaining(b.limits.MaxResults, b.results)
+	quota = remaining(b.limits.MaxQuota, b.quota)
+	b.mu.Unlock()
+	parentResults, parentQuota := b.parent.Headroom()
+	return minLimit(results, parentResults), minLimit(quota, parentQuota)
+}
+
+func remaining(limit, used int) int {
+	if limit <= 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// minLimit 返回较小的剩余量，-1表示不限制
+func minLimit(a, b int) int {
+	if a < 0 || (b >= 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (b *Budget) checkLocked() error {
+	if b.limits.MaxQuota > 0 && b.quota >= b.limits.MaxQuota {
+		return fmt.Errorf("%w: %s max quota %d", ErrLimitReached, b.name, b.limits.MaxQuota)
+	}
+	if b.limits.MaxResults > 0 && b.results >= b.limits.MaxResults {
+		return fmt.Errorf("%w: %s max results %d", ErrLimitReached, b.name, b.limits.MaxResults)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/404tk/cmap/sources"
+)
+
+// Info 证书中可用于精确查询的字段
+type Info struct {
+	Serial       string // 十进制序列号
+	SerialHex    string // 十六进制序列号
+	SHA1         string
+	SHA256       string
+	CommonName   string
+	Organization []string
+	SANs         []string
+}
+
+func (i *Info) String() string {
+	return fmt.Sprintf("CN=%s O=%s SN=%s SHA256=%s", i.CommonName,
+		strings.Join(i.Organization, ","), i.SerialHex, i.SHA256)
+}
+
+// Parse 解析PEM或DER格式的证书，PEM包含多张证书时仅取第一张
+func Parse(data []byte) (*Info, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	c, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+	return newInfo(c), nil
+}
+
+func newInfo(c *x509.Certificate) *Info {
+	sha1sum := sha1.Sum(c.Raw)
+	sha256sum := sha256.Sum256(c.Raw)
+	info := &Info{
+		Serial:       c.SerialNumber.String(),
+		SerialHex:    strings.ToLower(c.SerialNumber.Text(16)),
+		SHA1:         hex.EncodeToString(sha1sum[:]),
+		SHA256:       hex.EncodeToString(sha256sum[:]),
+		CommonName:   c.Subject.CommonName,
+		Organization: c.Subject.Organization,
+	}
+	info.SANs = append(info.SANs, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	return info
+}
+
+// Load 读取本地证书文件，文件不存在时视为TLS服务地址（host:port 或 https://host），
+// 通过session的IconClient获取其证书，与图标下载使用相同的代理、CA及证书校验配置
+func Load(ctx context.Context, session *sources.Session, target string) (*Info, error) {
+	if data, err := os.ReadFile(target); err == nil {
+		return Parse(data)
+	}
+
+	addr := target
+	if u, err := url.Parse(target); err == nil && len(u.Host) > 0 {
+		addr = u.Host
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "443")
+	}
+	// 记录请求使用的首个连接，证书读取后HTTP层的错误不影响结果
+	var state *tls.ConnectionState
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if c, ok := info.Conn.(*tls.Conn); ok && state == nil {
+				cs := c.ConnectionState()
+				state = &cs
+			}
+		},
+	}
+	request, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodHead, "https://"+addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := session.IconClient
+	if client == nil {
+		client = session.Client
+	}
+	resp, err := client.Do(request)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if state == nil || len(state.PeerCertificates) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no certificate received from %s", addr)
+	}
+	return newInfo(state.PeerCertificates[0]), nil
+}
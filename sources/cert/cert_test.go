@@ -0,0 +1,42 @@
+package cert
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/404tk/cmap/options"
+	"github.com/404tk/cmap/sources"
+)
+
+func TestLoadVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	insecure := true
+	tests := []struct {
+		name    string
+		icon    options.Transport
+		wantErr bool
+	}{
+		{"verify by default", options.Transport{}, true},
+		{"explicit insecure", options.Transport{Insecure: &insecure}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := sources.NewSession(&options.Options{Timeout: 5, IconTransport: tt.icon})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+			info, err := Load(context.Background(), session, server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && info.SHA256 != newInfo(server.Certificate()).SHA256 {
+				t.Errorf("Load() = %s, want %s", info, newInfo(server.Certificate()))
+			}
+		})
+	}
+}
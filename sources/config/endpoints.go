@@ -0,0 +1,8 @@
+package config
+
+var endpoints = make(map[string]string)
+
+// Endpoint 返回配置文件中指定引擎的接口地址，未配置时返回空字符串
+func Endpoint(name string) string {
+	return endpoints[name]
+}
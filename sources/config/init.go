@@ -33,18 +33,96 @@ func InitConfig(filename string) {
 		}
 	}
 	apikeys["fofa"] = fofa_keys
+	var censys_keys []CensysAuth
+	for _, i := range viper.GetStringSlice("auth.censys") {
+		item := strings.Split(i, ":")
+		if len(item) > 1 {
+			censys_keys = append(censys_keys, CensysAuth{
+				ID:     item[0],
+				Secret: item[1],
+			})
+		}
+	}
+	apikeys["censys"] = censys_keys
 	apikeys["hunter"] = viper.GetStringSlice("auth.hunter")
 	apikeys["quake"] = viper.GetStringSlice("auth.quake")
 	apikeys["shodan"] = viper.GetStringSlice("auth.shodan")
+	apikeys["zoomeye"] = viper.GetStringSlice("auth.zoomeye")
+	endpoints = viper.GetStringMapString("endpoint")
+	keyStrategy = viper.GetString("key_strategy")
+	for engine, items := range viper.GetStringMapStringSlice("key_ratelimit") {
+		limits := make(map[string]string)
+		for _, item := range items {
+			// key中可能包含"="，以最后一个"="分隔
+			if i := strings.LastIndex(item, "="); i > 0 {
+				limits[item[:i]] = item[i+1:]
+			}
+		}
+		keyRateLimits[engine] = limits
+	}
+	if err := viper.UnmarshalKey("limit", &limits); err != nil {
+		log.Fatalf("解析查询限制失败: %v\n", err)
+	}
+	if err := viper.UnmarshalKey("retry", &retry); err != nil {
+		log.Fatalf("解析重试策略失败: %v\n", err)
+	}
+	if err := viper.UnmarshalKey("transport", &transports); err != nil {
+		log.Fatalf("解析代理及TLS配置失败: %v\n", err)
+	}
 }
 
 const defaultConfigFile = `auth:
-fofa:
-  # - example@gmail.com:8ccxxcccxxxccxxxxcccccxxxccccddd
-hunter:
-  # - 8ccxxcccxxxccxxxxcccccxxxccccddd9ccxxcccxxxccxxxxcccccxxxccccddd
-quake:
-  # - 12345678-abcd-efgh-ijkl-123456789012
-shodan:
-  # - 8ccxxcDExxxccxxxxcccFGxxxccccddd
+  fofa:
+    # - example@gmail.com:8ccxxcccxxxccxxxxcccccxxxccccddd
+  hunter:
+    # - 8ccxxcccxxxccxxxxcccccxxxccccddd9ccxxcccxxxccxxxxcccccxxxccccddd
+  quake:
+    # - 12345678-abcd-efgh-ijkl-123456789012
+  shodan:
+    # - 8ccxxcDExxxccxxxxcccFGxxxccccddd
+  zoomeye:
+    # - 12345678-ABCD-efgh-1234-abcdef123456
+  censys:
+    # - 12345678-abcd-efgh-ijkl-123456789012:8ccxxcccxxxccxxxxcccccxxxccccddd
+# 请求失败时的重试策略，max_retries为-1时不重试
+retry:
+  max_retries: 3
+  min_wait: 1s
+  max_wait: 30s
+# key轮换策略: random, round-robin, least-used
+key_strategy: random
+# 单个key的请求频率，可指定等级(free、paid)或频率(如10/s、100/m)，未配置的key按free等级限制
+key_ratelimit:
+  shodan:
+    # - 8ccxxcDExxxccxxxxcccFGxxxccccddd=paid
+  fofa:
+    # - example@gmail.com=5/s
+# 代理、TLS及请求头配置，引擎未配置的字段使用global中的配置
+# proxy支持http、https、socks5，为空时使用环境变量中的代理，direct表示不使用代理
+transport:
+  global:
+    # insecure: false
+    # ca: /path/to/ca.pem
+  # shodan:
+  #   proxy: socks5://127.0.0.1:1080
+  #   user_agent: curl/8.7.1
+  #   headers:
+  #     X-Trace: cmap
+  # fofa:
+  #   proxy: direct
+  #   client_cert: /path/to/client.pem
+  #   client_key: /path/to/client.key
+  # 下载目标站点图标及证书，默认校验证书，不受global中insecure的影响，自签名证书的站点需显式关闭
+  # icon:
+  #   insecure: true
+# 自定义接口地址，支持私有化部署、镜像及路径前缀
+endpoint:
+  # fofa: https://fofa.example.com
+  # crtsh: https://crt.sh
+limit:
+  # global:
+  #   max_results: 1000
+  # fofa:
+  #   max_pages: 5
+  #   max_quota: 500
 `
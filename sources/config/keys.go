@@ -1,33 +1,81 @@
 package config
 
-import (
-	"math/rand"
-)
+import "encoding/base64"
 
 var apikeys = make(map[string]interface{})
 
+var keyStrategy string
+
+var keyRateLimits = make(map[string]map[string]string)
+
 type FofaAuth struct {
 	Email string
 	Key   string
 }
 
-func RandomKey(name string) interface{} {
-	v, ok := apikeys[name]
-	if !ok {
-		return nil
+type CensysAuth struct {
+	ID     string
+	Secret string
+}
+
+// KeyStrategy 返回配置文件中的key轮换策略
+func KeyStrategy() string {
+	return keyStrategy
+}
+
+// KeyRateLimit 返回配置文件中单个key的请求频率，可为等级或具体频率，未配置时返回空字符串
+func KeyRateLimit(name, id string) string {
+	return keyRateLimits[name][id]
+}
+
+// KeyID 返回key的标识，fofa为邮箱，censys为API ID
+func KeyID(key interface{}) string {
+	switch k := key.(type) {
+	case FofaAuth:
+		return k.Email
+	case CensysAuth:
+		return k.ID
+	case string:
+		return k
 	}
+	return ""
+}
 
-	if name == "fofa" {
-		auths := v.([]FofaAuth)
-		if len(auths) < 1 {
-			return nil
+// Keys 返回指定引擎配置的全部key
+func Keys(name string) []interface{} {
+	var res []interface{}
+	switch keys := apikeys[name].(type) {
+	case []FofaAuth:
+		for _, k := range keys {
+			res = append(res, k)
+		}
+	case []CensysAuth:
+		for _, k := range keys {
+			res = append(res, k)
+		}
+	case []string:
+		for _, k := range keys {
+			res = append(res, k)
 		}
-		return auths[rand.Intn(len(auths))]
-	} else {
-		keys := v.([]string)
-		if len(keys) < 1 {
-			return nil
+	}
+	return res
+}
+
+// Secrets 返回配置文件中全部需要隐去的key内容，censys同时包含Basic认证的编码值
+func Secrets() []string {
+	var res []string
+	for name := range apikeys {
+		for _, key := range Keys(name) {
+			switch k := key.(type) {
+			case FofaAuth:
+				res = append(res, k.Key)
+			case CensysAuth:
+				res = append(res, k.Secret,
+					base64.StdEncoding.EncodeToString([]byte(k.ID+":"+k.Secret)))
+			case string:
+				res = append(res, k)
+			}
 		}
-		return keys[rand.Intn(len(keys))]
 	}
+	return res
 }
@@ -0,0 +1,17 @@
+package config
+
+import "github.com/404tk/cmap/options"
+
+var limits = make(map[string]options.Limits)
+
+var retry options.Retry
+
+// Limits 返回配置文件中指定引擎的查询限制，name为global时返回全局限制
+func Limits(name string) options.Limits {
+	return limits[name]
+}
+
+// Retry 返回配置文件中的重试策略
+func Retry() options.Retry {
+	return retry
+}
@@ -0,0 +1,10 @@
+package config
+
+import "github.com/404tk/cmap/options"
+
+var transports = make(map[string]options.Transport)
+
+// Transport 返回配置文件中指定引擎的代理、TLS及请求头配置，name为global时返回全局配置
+func Transport(name string) options.Transport {
+	return transports[name]
+}
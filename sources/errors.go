@@ -0,0 +1,143 @@
+package sources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrorKind 引擎错误的分类
+type ErrorKind string
+
+const (
+	KindAuth      ErrorKind = "auth"       // key无效或无权限
+	KindQuota     ErrorKind = "quota"      // 额度耗尽
+	KindRateLimit ErrorKind = "rate limit" // 请求过于频繁
+	KindSyntax    ErrorKind = "syntax"     // 查询语法错误
+	KindNoResults ErrorKind = "no results" // 无查询结果
+	KindNetwork   ErrorKind = "network"    // 网络错误或服务端异常
+	KindDecode    ErrorKind = "decode"     // 响应格式错误
+	KindUnknown   ErrorKind = "unknown"
+)
+
+// EngineError 引擎返回的错误，Code及Message为引擎原始的错误码及错误信息
+type EngineError struct {
+	Kind    ErrorKind
+	Engine  string
+	KeyID   string // key在配置文件中的标识，如fofa#0，不含key内容
+	Code    string
+	Message string
+	Err     error
+}
+
+func NewEngineError(kind ErrorKind, engine, code, message string) *EngineError {
+	return &EngineError{Kind: kind, Engine: engine, Code: code, Message: message}
+}
+
+func (e *EngineError) Error() string {
+	msg := e.Message
+	if len(msg) == 0 && e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if len(e.Code) > 0 && !strings.Contains(msg, e.Code) {
+		msg = fmt.Sprintf("[%s] %s", e.Code, msg)
+	}
+	res := fmt.Sprintf("%s error: %s", e.Kind, msg)
+	if len(e.KeyID) > 0 {
+		res += fmt.Sprintf(" (key %s)", e.KeyID)
+	}
+	// 引擎返回的错误信息中可能带有key
+	return Redact(res)
+}
+
+// Unwrap 认证及额度错误可通过ErrKeyUnusable判断，频率错误可通过ErrTooFrequent判断
+func (e *EngineError) Unwrap() []error {
+	errs := []error{}
+	switch e.Kind {
+	case KindAuth, KindQuota:
+		errs = append(errs, ErrKeyUnusable)
+	case KindRateLimit:
+		errs = append(errs, ErrTooFrequent)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// KindOf 返回err的错误分类，非引擎错误返回空字符串
+func KindOf(err error) ErrorKind {
+	var e *EngineError
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ""
+}
+
+// statusKind 按HTTP状态码分类
+func statusKind(code int) ErrorKind {
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return KindAuth
+	case code == http.StatusPaymentRequired:
+		return KindQuota
+	case code == http.StatusTooManyRequests:
+		return KindRateLimit
+	case code == http.StatusBadRequest:
+		return KindSyntax
+	case code == http.StatusNotFound:
+		return KindNoResults
+	case code >= 500:
+		return KindNetwork
+	}
+	return KindUnknown
+}
+
+// Classify 将解析响应时的错误转换为EngineError，已分类的错误原样返回
+func Classify(engine string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *EngineError
+	if errors.As(err, &e) {
+		if len(e.Engine) == 0 {
+			e.Engine = engine
+		}
+		return err
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, ErrResponseTooLarge):
+		return &EngineError{Kind: KindDecode, Engine: engine, Err: err}
+	case errors.As(err, &netErr):
+		return &EngineError{Kind: KindNetwork, Engine: engine, Err: err}
+	}
+	return err
+}
+
+// errorMessage 读取错误响应中的错误信息后关闭响应体
+func errorMessage(body io.ReadCloser) string {
+	defer DrainBody(body)
+	data, _ := io.ReadAll(io.LimitReader(body, 4<<10))
+	var v map[string]interface{}
+	if json.Unmarshal(data, &v) == nil {
+		for _, k := range []string{"error", "errmsg", "message", "msg"} {
+			if msg, ok := v[k].(string); ok && len(msg) > 0 {
+				return msg
+			}
+		}
+	}
+	msg := strings.TrimSpace(string(data))
+	if len(msg) > 256 || strings.HasPrefix(msg, "<") {
+		// 忽略html错误页面
+		return ""
+	}
+	return msg
+}
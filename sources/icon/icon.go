@@ -0,0 +1,84 @@
+package icon
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/utils"
+)
+
+// MaxIconSize 图标文件大小上限
+const MaxIconSize = 10 << 20
+
+// Hash 计算图标的md5及shodan风格的mmh3（对base64编码内容计算）
+func Hash(data []byte) (md5sum, mmh3 string) {
+	sum := md5.Sum(data)
+	md5sum = hex.EncodeToString(sum[:])
+	mmh3 = strconv.Itoa(int(utils.Mmh3(encodeBase64(data))))
+	return
+}
+
+// encodeBase64 与python的base64.encodebytes一致，每76个字符换行且末尾带换行符
+func encodeBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteByte('\n')
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// Load 读取本地图标文件，或通过session的IconClient下载图标URL
+func Load(ctx context.Context, session *sources.Session, target string) ([]byte, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return os.ReadFile(target)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	// 未指定路径时默认下载站点根目录的favicon.ico
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/favicon.ico"
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := session.IconClient
+	if client == nil {
+		client = session.Client
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d received from %s", resp.StatusCode, u.String())
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxIconSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty icon from %s", u.String())
+	}
+	return data, nil
+}
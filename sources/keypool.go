@@ -0,0 +1,167 @@
+package sources
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// key轮换策略
+const (
+	KeyRandom     = "random"
+	KeyRoundRobin = "round-robin"
+	KeyLeastUsed  = "least-used"
+)
+
+// ErrKeyUnusable key无效或额度耗尽，应隔离该key并换下一个key重试
+var ErrKeyUnusable = errors.New("key unusable")
+
+// KeyPool 管理单个引擎的全部key，记录使用次数并隔离不可用的key
+type KeyPool struct {
+	mu       sync.Mutex
+	name     string
+	strategy string
+	keys     []*poolKey
+	next     int
+	lastErr  error
+	// limits 各key的请求频率，优先选择当前可立即发送请求的key
+	limits *Limiters
+}
+
+type poolKey struct {
+	value       interface{}
+	id          string
+	used        int
+	quarantined bool
+}
+
+func NewKeyPool(name string, keys []interface{}, strategy string) (*KeyPool, error) {
+	switch strategy {
+	case "":
+		strategy = KeyRandom
+	case KeyRandom, KeyRoundRobin, KeyLeastUsed:
+	default:
+		return nil, fmt.Errorf("unknown key strategy %s", strategy)
+	}
+	p := &KeyPool{name: name, strategy: strategy}
+	for i, k := range keys {
+		p.keys = append(p.keys, &poolKey{value: k, id: fmt.Sprintf("%s#%d", name, i)})
+	}
+	return p, nil
+}
+
+// Len 返回可用key的数量
+func (p *KeyPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.available())
+}
+
+// Get 按轮换策略返回一个可用key，全部key不可用时返回错误
+func (p *KeyPool) Get() (interface{}, error) {
+	if p == nil {
+		return nil, fmt.Errorf("empty keys")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := p.available()
+	if len(keys) == 0 {
+		if p.lastErr != nil {
+			return nil, fmt.Errorf("all %s keys are unusable, last error: %w", p.name, p.lastErr)
+		}
+		return nil, fmt.Errorf("empty %s keys", p.name)
+	}
+	if ready := p.ready(keys); len(ready) > 0 {
+		keys = ready
+	}
+
+	var k *poolKey
+	switch p.strategy {
+	case KeyRoundRobin:
+		k = keys[p.next%len(keys)]
+		p.next++
+	case KeyLeastUsed:
+		k = keys[0]
+		for _, v := range keys[1:] {
+			if v.used < k.used {
+				k = v
+			}
+		}
+	default:
+		k = keys[rand.Intn(len(keys))]
+	}
+	k.used++
+	return k.value, nil
+}
+
+// Quarantined 返回本次运行中停用的key数量
+func (p *KeyPool) Quarantined() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, k := range p.keys {
+		if k.quarantined {
+			n++
+		}
+	}
+	return n
+}
+
+// Quarantine 在本次运行中停用key
+func (p *KeyPool) Quarantine(key interface{}, reason error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		if k.value == key {
+			k.quarantined = true
+		}
+	}
+	p.lastErr = reason
+}
+
+// ready 过滤出未达到频率限制的key
+func (p *KeyPool) ready(keys []*poolKey) []*poolKey {
+	if p.limits == nil {
+		return keys
+	}
+	var res []*poolKey
+	for _, k := range keys {
+		if p.limits.CanTake(k.id) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// ID 返回key的标识，如fofa#0，同时作为该key的频率限制标识
+func (p *KeyPool) ID(key interface{}) string {
+	if p == nil {
+		return ""
+	}
+	for _, k := range p.keys {
+		if k.value == key {
+			return k.id
+		}
+	}
+	return ""
+}
+
+func (p *KeyPool) available() []*poolKey {
+	var keys []*poolKey
+	for _, k := range p.keys {
+		if !k.quarantined {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
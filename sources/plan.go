@@ -0,0 +1,27 @@
+package sources
+
+import "context"
+
+// Plan 描述一次查询将要发送的请求，用于dry-run时预览查询语句及额度消耗
+type Plan struct {
+	Source   string
+	Query    string // 与Result.Prompt一致
+	Endpoint string // 接口地址，不含key等参数
+	Total    int    // 预计结果数，-1表示未知
+	Pages    int    // 预计页数，-1表示未知
+	Cost     int    // 预计消耗额度，-1表示未知
+	Error    error
+}
+
+type planningKey struct{}
+
+// WithPlanning 标记ctx为dry-run，插件仅生成查询计划而不发送计费请求
+func WithPlanning(ctx context.Context) context.Context {
+	return context.WithValue(ctx, planningKey{}, true)
+}
+
+// IsPlanning 判断ctx是否为dry-run
+func IsPlanning(ctx context.Context) bool {
+	v, _ := ctx.Value(planningKey{}).(bool)
+	return v
+}
@@ -0,0 +1,36 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/404tk/cmap/sources"
+)
+
+// getJSON 请求账户信息等不计费的接口并解析返回的JSON
+func getJSON(ctx context.Context, session *sources.Session, source string, req *sources.Req, v interface{}) error {
+	request, err := req.Request(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := session.Do(request, source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return sources.Classify(source, json.NewDecoder(resp.Body).Decode(v))
+}
+
+// parseQuota 提取额度描述中的数字，如"今日剩余积分：500"
+func parseQuota(s string) (int, bool) {
+	digits := strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return c
+		}
+		return -1
+	}, s)
+	n, err := strconv.Atoi(digits)
+	return n, err == nil
+}
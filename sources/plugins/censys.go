@@ -0,0 +1,307 @@
+package plugins
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/404tk/cmap/query"
+	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
+	"github.com/404tk/cmap/sources/config"
+)
+
+const (
+	CensysSize = 100
+)
+
+type Censys struct {
+	domainMode DomainMatch
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+var censysDialect = &query.Dialect{
+	Fields: map[string]string{
+		"ip":       `ip: %s`,
+		"domain":   `dns.names: "%s"`,
+		"port":     `services.port: %s`,
+		"title":    `services.http.response.html_title: "%s"`,
+		"body":     `services.http.response.body: "%s"`,
+		"cert":     `services.tls.certificates.leaf_data.subject_dn: "%s"`,
+		"icon.md5": `services.http.response.favicons.md5_hash: "%s"`,
+		"org":      `autonomous_system.name: "%s"`,
+		"asn":      `autonomous_system.asn: %s`,
+		"country":  `location.country_code: "%s"`,
+	},
+	And:    " and ",
+	Or:     " or ",
+	Not:    "not ",
+	Escape: query.QuoteEscape,
+}
+
+func (f Censys) Name() string {
+	return "censys"
+}
+
+func (f Censys) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	if session.KeyPool(f.Name()).Len() == 0 {
+		return nil, fmt.Errorf("empty %s keys", f.Name())
+	}
+	f.session = session
+	f.results = make(chan sources.Result)
+
+	k := query.(Keyword)
+	f.domainMode = k.DomainMode
+	go func() {
+		defer close(f.results)
+		dispatch(ctx, f, k, iconMd5, f.search)
+	}()
+
+	return f.results, nil
+}
+
+func (f Censys) QueryIP(ctx context.Context, ip string) {
+	if len(ip) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`ip: %s`, ip)
+	f.search(ctx, query)
+}
+
+func (f Censys) QueryDomain(ctx context.Context, domain string) {
+	if len(domain) == 0 {
+		return
+	}
+	domain, exact := domainScope(domain, f.domainMode)
+	query := fmt.Sprintf(`dns.names: "%s" or dns.names: *.%s`, domain, domain)
+	if exact {
+		query = fmt.Sprintf(`dns.names: "%s"`, domain)
+	}
+	f.search(ctx, query)
+}
+
+func (f Censys) QueryIcon(ctx context.Context, hash string) {
+	if len(hash) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`services.http.response.favicons.md5_hash: "%s"`, hash)
+	f.search(ctx, query)
+}
+
+func (f Censys) QueryCert(ctx context.Context, keyword string) {
+	if len(keyword) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`services.tls.certificates.leaf_data.subject_dn: "%s"`, censysDialect.EscapeValue(keyword))
+	f.search(ctx, query)
+}
+
+func (f Censys) QueryExpr(ctx context.Context, expr query.Node) {
+	q, err := censysDialect.Translate(expr)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	f.search(ctx, q)
+}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f Censys) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	query := fmt.Sprintf(`services.tls.certificates.leaf_data.fingerprint: %s`, c.SHA256)
+	f.search(ctx, query)
+}
+
+type CensysResponse struct {
+	Code   int    `json:"code"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Result struct {
+		Query string `json:"query"`
+		Total int    `json:"total"`
+		Hits  []struct {
+			IP       string `json:"ip"`
+			Services []struct {
+				Port                int    `json:"port"`
+				ServiceName         string `json:"service_name"`
+				ExtendedServiceName string `json:"extended_service_name"`
+				TransportProtocol   string `json:"transport_protocol"`
+				Software            []struct {
+					Product string `json:"product"`
+				} `json:"software"`
+				Http struct {
+					Response struct {
+						HtmlTitle string `json:"html_title"`
+					} `json:"response"`
+				} `json:"http"`
+			} `json:"services"`
+			DNS struct {
+				Names []string `json:"names"`
+			} `json:"dns"`
+			LastUpdatedAt string `json:"last_updated_at"`
+		} `json:"hits"`
+		Links struct {
+			Prev string `json:"prev"`
+			Next string `json:"next"`
+		} `json:"links"`
+	} `json:"result"`
+}
+
+func (f Censys) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
+	var cursor string
+	for {
+		build := func(key interface{}) *sources.Req {
+			auth, _ := key.(config.CensysAuth)
+			req := &sources.Req{
+				Schema:   "https",
+				Endpoint: "search.censys.io",
+				Path:     "/api/v2/hosts/search",
+				Method:   "GET",
+				Header: map[string]string{
+					"Accept": "application/json",
+					"Authorization": "Basic " + base64.StdEncoding.EncodeToString(
+						[]byte(auth.ID+":"+auth.Secret)),
+				},
+			}
+			// 每台主机至少对应一条结果，按剩余结果数减少每页数量
+			req.Query = fmt.Sprintf("q=%s&per_page=%d", url.QueryEscape(query), pageSize(budget, CensysSize, false))
+			if len(cursor) > 0 {
+				req.Query += "&cursor=" + url.QueryEscape(cursor)
+			}
+			return req
+		}
+		if sources.IsPlanning(ctx) {
+			sendPlan(ctx, f.results, f.Name(), query, build(nil))
+			return
+		}
+		if err := budget.TakePage(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+			return
+		}
+		// 凭证无效或额度耗尽时接口返回401或403
+		var censysResponse *CensysResponse
+		_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+			censysResponse = &CensysResponse{}
+			if err := json.NewDecoder(resp.Body).Decode(censysResponse); err != nil {
+				return err
+			}
+			if censysResponse.Code != 200 {
+				return sources.NewEngineError(messageKind(censysResponse.Error), f.Name(),
+					strconv.Itoa(censysResponse.Code), censysResponse.Error)
+			}
+			return nil
+		})
+		if err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+			return
+		}
+		// 每页消耗1个查询积分
+		budget.Spend(1)
+
+		for _, hit := range censysResponse.Result.Hits {
+			var lastUpdate string
+			parsedTime, err := time.Parse(time.RFC3339Nano, hit.LastUpdatedAt)
+			if err == nil {
+				lastUpdate = parsedTime.Format(time.DateTime)
+			}
+			// 每个服务对应一条结果
+			for _, service := range hit.Services {
+				if err := budget.TakeResult(); err != nil {
+					send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+					return
+				}
+				result := sources.Result{Source: f.Name()}
+				result.IP = hit.IP
+				result.Port = fmt.Sprintf("%d/%s", service.Port, strings.ToLower(service.TransportProtocol))
+				result.Protocol = strings.ToLower(service.ExtendedServiceName)
+				if len(result.Protocol) == 0 {
+					result.Protocol = strings.ToLower(service.ServiceName)
+				}
+				result.Host = hit.DNS.Names
+				if service.ServiceName == "HTTP" {
+					result.Title = service.Http.Response.HtmlTitle
+					if result.Protocol == "https" {
+						result.Url = fmt.Sprintf("https://%s", result.IpPort())
+					} else {
+						result.Url = fmt.Sprintf("http://%s", result.IpPort())
+					}
+				}
+				if len(service.Software) > 0 {
+					result.Fingerprint = service.Software[0].Product
+				}
+				result.LastUpdate = lastUpdate
+				result.Prompt = query
+				if !send(ctx, f.results, result) {
+					return
+				}
+			}
+		}
+
+		cursor = censysResponse.Result.Links.Next
+		if len(cursor) == 0 || len(censysResponse.Result.Hits) == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			continue
+		}
+	}
+}
+
+type CensysAccountResponse struct {
+	Error string `json:"error"`
+	Quota struct {
+		Used      int    `json:"used"`
+		Allowance int    `json:"allowance"`
+		ResetsAt  string `json:"resets_at"`
+	} `json:"quota"`
+}
+
+func (f Censys) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	var accounts []sources.Account
+	for _, key := range config.Keys(f.Name()) {
+		auth := key.(config.CensysAuth)
+		account := sources.Account{Source: f.Name(), Key: auth.ID, Remaining: -1}
+		req := &sources.Req{
+			Schema:   "https",
+			Endpoint: "search.censys.io",
+			Path:     "/api/v1/account",
+			Method:   "GET",
+			Header: map[string]string{
+				"Accept": "application/json",
+				"Authorization": "Basic " + base64.StdEncoding.EncodeToString(
+					[]byte(auth.ID+":"+auth.Secret)),
+			},
+		}
+		resp := &CensysAccountResponse{}
+		if err := getJSON(ctx, session, f.Name(), req, resp); err != nil {
+			account.Error = err
+		} else if len(resp.Error) > 0 {
+			account.Error = sources.NewEngineError(messageKind(resp.Error), f.Name(), "", resp.Error)
+		} else {
+			account.Plan = fmt.Sprintf("%d/month", resp.Quota.Allowance)
+			account.Remaining = resp.Quota.Allowance - resp.Quota.Used
+			// censys为额度重置时间
+			account.Expiry = resp.Quota.ResetsAt
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+func init() {
+	registerPlugin("censys", Censys{})
+}
@@ -0,0 +1,169 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/404tk/cmap/query"
+	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
+)
+
+// Crtsh 基于证书透明度日志查询关联域名，无需key
+type Crtsh struct {
+	domainMode DomainMatch
+	scope      string
+	exact      bool
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+func (f Crtsh) Name() string {
+	return "crtsh"
+}
+
+func (f Crtsh) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	f.session = session
+	f.results = make(chan sources.Result)
+
+	k := query.(Keyword)
+	f.domainMode = k.DomainMode
+	go func() {
+		defer close(f.results)
+		dispatch(ctx, f, k, iconMd5, f.search)
+	}()
+
+	return f.results, nil
+}
+
+// QueryIP 证书透明度日志不支持IP查询
+func (f Crtsh) QueryIP(ctx context.Context, ip string) {}
+
+func (f Crtsh) QueryDomain(ctx context.Context, domain string) {
+	if len(domain) == 0 {
+		return
+	}
+	domain, exact := domainScope(domain, f.domainMode)
+	// %.domain 会漏掉仅包含域名本身的证书，因此按后缀查询，
+	// 再过滤证书中的无关域名及 notdomain 这类后缀相同的域名
+	f.scope, f.exact = domain, exact
+	query := "%" + domain
+	if exact {
+		query = domain
+	}
+	f.search(ctx, query)
+}
+
+// QueryIcon 证书透明度日志不支持图标查询
+func (f Crtsh) QueryIcon(ctx context.Context, hash string) {}
+
+func (f Crtsh) QueryCert(ctx context.Context, keyword string) {
+	if len(keyword) == 0 {
+		return
+	}
+	f.search(ctx, keyword)
+}
+
+// QueryExpr 证书透明度日志不支持组合查询
+func (f Crtsh) QueryExpr(ctx context.Context, expr query.Node) {}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f Crtsh) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	f.search(ctx, c.SHA256)
+}
+
+type CrtshResponse []struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	CommonName     string `json:"common_name"`
+	NameValue      string `json:"name_value"`
+	SerialNumber   string `json:"serial_number"`
+	EntryTimestamp string `json:"entry_timestamp"`
+}
+
+func (f Crtsh) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
+	req := &sources.Req{
+		Schema:   "https",
+		Endpoint: "crt.sh",
+		Path:     "/",
+		Method:   "GET",
+		Header:   map[string]string{"Accept": "application/json"},
+		Query:    fmt.Sprintf("q=%s&output=json", url.QueryEscape(query)),
+	}
+	if sources.IsPlanning(ctx) {
+		sendCountPlan(ctx, f.results, f.Name(), query, req, -1, 1, 0)
+		return
+	}
+	if err := budget.TakePage(); err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+		return
+	}
+	request, err := req.Request(ctx)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	resp, err := f.session.Do(request, f.Name())
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	defer resp.Body.Close()
+
+	crtshResponse := CrtshResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&crtshResponse); err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: sources.Classify(f.Name(), err)})
+		return
+	}
+
+	// 同一域名可能出现在多张证书中，仅保留首次出现的记录
+	seen := make(map[string]struct{})
+	for _, entry := range crtshResponse {
+		var lastUpdate string
+		parsedTime, err := time.Parse("2006-01-02T15:04:05", strings.Split(entry.EntryTimestamp, ".")[0])
+		if err == nil {
+			lastUpdate = parsedTime.Format(time.DateTime)
+		}
+		for _, name := range strings.Split(entry.NameValue, "\n") {
+			name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "*.")
+			if len(name) == 0 || strings.Contains(name, "@") {
+				continue
+			}
+			if len(f.scope) > 0 && !inDomainScope(name, f.scope, f.exact) {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			if err := budget.TakeResult(); err != nil {
+				send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+				return
+			}
+			result := sources.Result{Source: f.Name()}
+			result.Host = []string{name}
+			result.LastUpdate = lastUpdate
+			result.Prompt = query
+			if !send(ctx, f.results, result) {
+				return
+			}
+		}
+	}
+}
+
+// AccountInfo crt.sh无需key
+func (f Crtsh) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	return nil
+}
+
+func init() {
+	registerPlugin("crtsh", Crtsh{})
+}
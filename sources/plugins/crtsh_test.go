@@ -0,0 +1,96 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/404tk/cmap/options"
+	"github.com/404tk/cmap/sources"
+)
+
+func TestCrtshQueryDomain(t *testing.T) {
+	entries := `[
+		{"name_value":"example.com","entry_timestamp":"2024-01-02T03:04:05.678"},
+		{"name_value":"*.example.com\nwww.example.com","entry_timestamp":"2024-01-02T03:04:05"},
+		{"name_value":"API.example.com\nadmin@example.com","entry_timestamp":"2024-01-02T03:04:05"},
+		{"name_value":"notexample.com\nexample.org","entry_timestamp":"2024-01-02T03:04:05"}
+	]`
+	tests := []struct {
+		name  string
+		mode  DomainMatch
+		query string
+		want  []string
+	}{
+		{"sub keeps apex", DomainSubdomains, "%example.com", []string{"api.example.com", "example.com", "www.example.com"}},
+		{"exact", DomainExact, "example.com", []string{"example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if q := r.URL.Query().Get("q"); q != tt.query {
+					t.Errorf("q = %q, want %q", q, tt.query)
+				}
+				fmt.Fprint(w, entries)
+			}))
+			defer srv.Close()
+			session, err := sources.NewSession(&options.Options{
+				Agents:    []string{"crtsh"},
+				Timeout:   5,
+				Endpoints: map[string]string{"crtsh": srv.URL},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.Close()
+
+			ch, err := Crtsh{}.Query(context.Background(), session, Keyword{Domain: []string{"example.com"}, DomainMode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var hosts []string
+			for result := range ch {
+				if result.Error != nil {
+					t.Fatal(result.Error)
+				}
+				hosts = append(hosts, result.Host...)
+			}
+			sort.Strings(hosts)
+			if !reflect.DeepEqual(hosts, tt.want) {
+				t.Errorf("hosts = %v, want %v", hosts, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrtshDecodeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>busy</html>")
+	}))
+	defer srv.Close()
+	session, err := sources.NewSession(&options.Options{
+		Agents:    []string{"crtsh"},
+		Timeout:   5,
+		Endpoints: map[string]string{"crtsh": srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	ch, err := Crtsh{}.Query(context.Background(), session, Keyword{Domain: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kind sources.ErrorKind
+	for result := range ch {
+		kind = sources.KindOf(result.Error)
+	}
+	if kind != sources.KindDecode {
+		t.Errorf("KindOf() = %q, want %q", kind, sources.KindDecode)
+	}
+}
@@ -0,0 +1,98 @@
+package plugins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/404tk/cmap/query"
+)
+
+func TestDialectTranslate(t *testing.T) {
+	const (
+		md5  = "d41d8cd98f00b204e9800998ecf8427e"
+		mmh3 = "-1234567"
+	)
+	tests := []struct {
+		input string
+		want  map[string]string
+	}{
+		{
+			input: `title:"a\"b" AND port:443`,
+			want: map[string]string{
+				"fofa":    `title="a\"b" && port="443"`,
+				"hunter":  `web.title="a\"b" && ip.port="443"`,
+				"quake":   `title:"a\"b" AND port:443`,
+				"shodan":  `http.title:"ab" port:443`,
+				"zoomeye": `title:"a\"b" +port:443`,
+				"censys":  `services.http.response.html_title: "a\"b" and services.port: 443`,
+			},
+		},
+		{
+			input: `domain:例子.测试 AND (port:80 OR port:443)`,
+			want: map[string]string{
+				"fofa":    `domain="xn--fsqu00a.xn--0zwm56d" && (port="80" || port="443")`,
+				"hunter":  `domain.suffix="xn--fsqu00a.xn--0zwm56d" && (ip.port="80" || ip.port="443")`,
+				"quake":   `domain:"xn--fsqu00a.xn--0zwm56d" AND (port:80 OR port:443)`,
+				"zoomeye": `hostname:"xn--fsqu00a.xn--0zwm56d" +(port:80 port:443)`,
+				"censys":  `dns.names: "xn--fsqu00a.xn--0zwm56d" and (services.port: 80 or services.port: 443)`,
+			},
+		},
+		{
+			input: `ip:10.0.0.0/8 AND NOT country:CN`,
+			want: map[string]string{
+				"fofa":    `ip="10.0.0.0/8" && country!="CN"`,
+				"hunter":  `ip="10.0.0.0/8" && ip.country!="CN"`,
+				"quake":   `ip:"10.0.0.0/8" AND NOT country:"CN"`,
+				"shodan":  `net:"10.0.0.0/8" -country:"CN"`,
+				"zoomeye": `cidr:"10.0.0.0/8" -country:"CN"`,
+				"censys":  `ip: 10.0.0.0/8 and not location.country_code: "CN"`,
+			},
+		},
+		{
+			input: `icon:` + md5,
+			want: map[string]string{
+				"hunter":  `web.icon="` + md5 + `"`,
+				"quake":   `favicon:"` + md5 + `"`,
+				"zoomeye": `iconhash:"` + md5 + `"`,
+				"censys":  `services.http.response.favicons.md5_hash: "` + md5 + `"`,
+			},
+		},
+		{
+			input: `icon:` + mmh3,
+			want: map[string]string{
+				"fofa":    `icon_hash="` + mmh3 + `"`,
+				"shodan":  `http.favicon.hash:` + mmh3,
+				"zoomeye": `iconhash:"` + mmh3 + `"`,
+			},
+		},
+	}
+	dialects := map[string]*query.Dialect{
+		"fofa":    fofaDialect,
+		"hunter":  hunterDialect,
+		"quake":   quakeDialect,
+		"shodan":  shodanDialect,
+		"zoomeye": zoomeyeDialect,
+		"censys":  censysDialect,
+	}
+	for _, tt := range tests {
+		node, err := query.Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.input, err)
+		}
+		for name, dialect := range dialects {
+			got, err := dialect.Translate(node)
+			want, ok := tt.want[name]
+			if !ok {
+				// 未列出的引擎应返回UnsupportedError
+				var unsupported *query.UnsupportedError
+				if !errors.As(err, &unsupported) {
+					t.Errorf("%s.Translate(%q) = %q, %v, want UnsupportedError", name, tt.input, got, err)
+				}
+				continue
+			}
+			if err != nil || got != want {
+				t.Errorf("%s.Translate(%q) = %q, %v, want %q", name, tt.input, got, err, want)
+			}
+		}
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
-	"time"
 
+	"github.com/404tk/cmap/query"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
 	"github.com/404tk/cmap/sources/config"
 )
 
@@ -18,44 +22,61 @@ const (
 )
 
 type Fofa struct {
-	auth    config.FofaAuth
-	session *sources.Session
-	results chan sources.Result
+	domainMode DomainMatch
+	scope      string
+	exact      bool
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+var fofaDialect = &query.Dialect{
+	Fields: map[string]string{
+		"ip":        `ip="%s"`,
+		"domain":    `domain="%s"`,
+		"port":      `port="%s"`,
+		"title":     `title="%s"`,
+		"body":      `body="%s"`,
+		"header":    `header="%s"`,
+		"cert":      `cert="%s"`,
+		"icon.mmh3": `icon_hash="%s"`,
+		"org":       `org="%s"`,
+		"asn":       `asn="%s"`,
+		"country":   `country="%s"`,
+	},
+	NotFields: map[string]string{
+		"ip":        `ip!="%s"`,
+		"domain":    `domain!="%s"`,
+		"port":      `port!="%s"`,
+		"title":     `title!="%s"`,
+		"body":      `body!="%s"`,
+		"header":    `header!="%s"`,
+		"cert":      `cert!="%s"`,
+		"icon.mmh3": `icon_hash!="%s"`,
+		"org":       `org!="%s"`,
+		"asn":       `asn!="%s"`,
+		"country":   `country!="%s"`,
+	},
+	And:    " && ",
+	Or:     " || ",
+	Escape: query.QuoteEscape,
 }
 
 func (f Fofa) Name() string {
 	return "fofa"
 }
 
-func (f Fofa) Query(session *sources.Session, query interface{}) (chan sources.Result, error) {
-	apikey := config.RandomKey(f.Name())
-	if apikey == nil {
+func (f Fofa) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	if session.KeyPool(f.Name()).Len() == 0 {
 		return nil, fmt.Errorf("empty %s keys", f.Name())
 	}
-	f.auth = apikey.(config.FofaAuth)
 	f.session = session
 	f.results = make(chan sources.Result)
 
-	// 查询总时长限制10分钟
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-
 	k := query.(Keyword)
+	f.domainMode = k.DomainMode
 	go func() {
 		defer close(f.results)
-
-		for _, ip := range k.IP {
-			f.QueryIP(ctx, ip)
-		}
-		for _, domain := range k.Domain {
-			f.QueryDomain(ctx, domain)
-		}
-		for _, q := range k.Icon {
-			f.QueryIcon(ctx, q.Mmh3)
-		}
-		for _, cert := range k.Cert {
-			f.QueryCert(ctx, cert)
-		}
+		dispatch(ctx, f, k, iconMmh3, f.search)
 	}()
 
 	return f.results, nil
@@ -73,7 +94,13 @@ func (f Fofa) QueryDomain(ctx context.Context, domain string) {
 	if len(domain) == 0 {
 		return
 	}
-	query := fmt.Sprintf(`domain="%s"`, domain)
+	domain, exact := domainScope(domain, f.domainMode)
+	// fofa的host为包含匹配且带有协议及端口，domain仅匹配根域名，在结果中按host过滤
+	f.scope, f.exact = domain, exact
+	query := fmt.Sprintf(`host="%s"`, domain)
+	if f.domainMode == DomainRoot {
+		query = fmt.Sprintf(`domain="%s"`, domain)
+	}
 	f.search(ctx, query)
 }
 
@@ -89,74 +116,96 @@ func (f Fofa) QueryCert(ctx context.Context, keyword string) {
 	if len(keyword) == 0 {
 		return
 	}
-	query := fmt.Sprintf(`cert="%s"`, keyword)
+	query := fmt.Sprintf(`cert="%s"`, fofaDialect.EscapeValue(keyword))
 	f.search(ctx, query)
 }
 
-// FofaResponse contains the fofa response
+func (f Fofa) QueryExpr(ctx context.Context, expr query.Node) {
+	q, err := fofaDialect.Translate(expr)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	f.search(ctx, q)
+}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f Fofa) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	query := fmt.Sprintf(`cert.sn="%s"`, c.Serial)
+	f.search(ctx, query)
+}
+
+// FofaResponse contains the fofa response, results are streamed by decodeStream
 type FofaResponse struct {
-	Error   bool       `json:"error"`
-	ErrMsg  string     `json:"errmsg"`
-	Mode    string     `json:"mode"`
-	Page    int        `json:"page"`
-	Query   string     `json:"query"`
-	Results [][]string `json:"results"`
-	Size    int        `json:"size"`
+	Error  bool   `json:"error"`
+	ErrMsg string `json:"errmsg"`
+	Mode   string `json:"mode"`
+	Page   int    `json:"page"`
+	Query  string `json:"query"`
+	Size   int    `json:"size"`
 }
 
 func (f Fofa) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
+	// 按页码分页，中途改变每页数量会打乱偏移，因此在查询开始时按剩余结果数及额度确定
+	size := pageSize(budget, FofaSize, true)
 	page := 1
 	for {
-		req := &sources.Req{
-			Schema:   "https",
-			Endpoint: "fofa.info",
-			Path:     "/api/v1/search/all",
-			Method:   "GET",
-			Header:   map[string]string{"Accept": "application/json"},
-		}
 		qbase64 := base64.StdEncoding.EncodeToString([]byte(query))
-		req.Query = fmt.Sprintf("mail=%s&key=%s&qbase64=%s&fields=%s&page=%d&size=%d",
-			f.auth.Email, f.auth.Key, qbase64, FofaFields, page, FofaSize)
-		request, err := req.Request()
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		build := func(key interface{}) *sources.Req {
+			auth, _ := key.(config.FofaAuth)
+			return &sources.Req{
+				Schema:   "https",
+				Endpoint: "fofa.info",
+				Path:     "/api/v1/search/all",
+				Method:   "GET",
+				Header:   map[string]string{"Accept": "application/json"},
+				Query: fmt.Sprintf("mail=%s&key=%s&qbase64=%s&fields=%s&page=%d&size=%d",
+					auth.Email, auth.Key, qbase64, FofaFields, page, size),
+			}
+		}
+		if sources.IsPlanning(ctx) {
+			sendPlan(ctx, f.results, f.Name(), query, build(nil))
 			return
 		}
-		resp, err := f.session.Do(request, f.Name())
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		if err := budget.TakePage(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
 			return
 		}
-
+		count := 0
 		fofaResponse := &FofaResponse{}
-		if err := json.NewDecoder(resp.Body).Decode(fofaResponse); err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+			*fofaResponse = FofaResponse{}
+			// 单页最多10000条，逐行解析并输出
+			err := decodeStream(resp.Body, fofaResponse, "results", func(raw json.RawMessage) error {
+				var row []string
+				if err := json.Unmarshal(raw, &row); err != nil {
+					return err
+				}
+				count++
+				return f.emit(ctx, budget, query, row)
+			})
+			if err != nil {
+				return err
+			}
+			if fofaResponse.Error {
+				return fofaError(fofaResponse.ErrMsg)
+			}
+			return nil
+		})
+		budget.Spend(count)
+		if errors.Is(err, errStop) {
 			return
 		}
-		if fofaResponse.Error {
-			f.results <- sources.Result{Source: f.Name(), Error: fmt.Errorf(fofaResponse.ErrMsg)}
+		if err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
 			return
 		}
 
-		for _, fofaResult := range fofaResponse.Results {
-			result := sources.Result{Source: f.Name()}
-			result.IP = fofaResult[0]
-			result.Port = fmt.Sprintf("%s/%s", fofaResult[1], fofaResult[2])
-			result.Protocol = fofaResult[3]
-			if len(fofaResult[4]) > 0 {
-				result.Host = append(result.Host, fofaResult[4])
-			}
-			if strings.HasPrefix(fofaResult[3], "http") {
-				result.Url = fofaResult[5]
-				result.Title = fofaResult[6]
-			}
-			result.Fingerprint = fofaResult[7]
-			result.LastUpdate = fofaResult[8]
-			result.Prompt = query
-			f.results <- result
-		}
-
-		if fofaResponse.Size < FofaSize || len(fofaResponse.Results) == 0 {
+		if count < size || page*size >= fofaResponse.Size {
 			return
 		}
 
@@ -169,6 +218,109 @@ func (f Fofa) search(ctx context.Context, query string) {
 	}
 }
 
+// emit 输出一行结果，达到结果数限制或ctx结束时返回errStop
+func (f Fofa) emit(ctx context.Context, budget *sources.Budget, query string, row []string) error {
+	if len(row) < 9 {
+		return sources.NewEngineError(sources.KindDecode, f.Name(), "", "wrong format")
+	}
+	if len(f.scope) > 0 && !inDomainScope(fofaHostname(row[5]), f.scope, f.exact) {
+		return nil
+	}
+	if err := budget.TakeResult(); err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+		return errStop
+	}
+	result := sources.Result{Source: f.Name()}
+	result.IP = row[0]
+	result.Port = fmt.Sprintf("%s/%s", row[1], row[2])
+	result.Protocol = row[3]
+	if len(row[4]) > 0 {
+		result.Host = append(result.Host, row[4])
+	}
+	if strings.HasPrefix(row[3], "http") {
+		result.Url = row[5]
+		result.Title = row[6]
+	}
+	result.Fingerprint = row[7]
+	result.LastUpdate = row[8]
+	result.Prompt = query
+	if !send(ctx, f.results, result) {
+		return errStop
+	}
+	return nil
+}
+
+// fofaHostname 去掉host中的协议及端口，如"https://a.example.com:8443"
+func fofaHostname(host string) string {
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// fofaError 按错误码及错误信息分类，错误信息格式如"[-700] Account Invalid"
+func fofaError(msg string) error {
+	var code string
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "]"); i > 0 {
+			code = msg[1:i]
+		}
+	}
+	kind := messageKind(msg)
+	switch {
+	case code == "-700" || strings.Contains(msg, "Account Invalid"):
+		kind = sources.KindAuth
+	case code == "820031":
+		kind = sources.KindQuota
+	}
+	return sources.NewEngineError(kind, "fofa", code, msg)
+}
+
+type FofaAccountResponse struct {
+	Error          bool   `json:"error"`
+	ErrMsg         string `json:"errmsg"`
+	IsVip          bool   `json:"isvip"`
+	VipLevel       int    `json:"vip_level"`
+	RemainAPIData  int    `json:"remain_api_data"`
+	RemainAPIQuery int    `json:"remain_api_query"`
+	Expiration     string `json:"expiration"`
+}
+
+func (f Fofa) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	var accounts []sources.Account
+	for _, key := range config.Keys(f.Name()) {
+		auth := key.(config.FofaAuth)
+		account := sources.Account{Source: f.Name(), Key: auth.Email, Remaining: -1}
+		req := &sources.Req{
+			Schema:   "https",
+			Endpoint: "fofa.info",
+			Path:     "/api/v1/info/my",
+			Method:   "GET",
+			Header:   map[string]string{"Accept": "application/json"},
+			Query:    fmt.Sprintf("email=%s&key=%s", auth.Email, auth.Key),
+		}
+		resp := &FofaAccountResponse{}
+		if err := getJSON(ctx, session, f.Name(), req, resp); err != nil {
+			account.Error = err
+		} else if resp.Error {
+			account.Error = fofaError(resp.ErrMsg)
+		} else {
+			account.Plan = "free"
+			if resp.IsVip {
+				account.Plan = fmt.Sprintf("vip%d", resp.VipLevel)
+			}
+			// fofa按返回的数据条数扣除额度
+			account.Remaining = resp.RemainAPIData
+			account.Expiry = resp.Expiration
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
 func init() {
 	registerPlugin("fofa", Fofa{})
 }
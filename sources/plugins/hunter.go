@@ -5,9 +5,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/404tk/cmap/query"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
 	"github.com/404tk/cmap/sources/config"
 )
 
@@ -16,44 +20,61 @@ const (
 )
 
 type Hunter struct {
-	apikey  string
-	session *sources.Session
-	results chan sources.Result
+	domainMode DomainMatch
+	scope      string
+	exact      bool
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+var hunterDialect = &query.Dialect{
+	Fields: map[string]string{
+		"ip":       `ip="%s"`,
+		"domain":   `domain.suffix="%s"`,
+		"port":     `ip.port="%s"`,
+		"title":    `web.title="%s"`,
+		"body":     `web.body="%s"`,
+		"header":   `header="%s"`,
+		"cert":     `cert="%s"`,
+		"icon.md5": `web.icon="%s"`,
+		"org":      `as.org="%s"`,
+		"asn":      `as.number="%s"`,
+		"country":  `ip.country="%s"`,
+	},
+	NotFields: map[string]string{
+		"ip":       `ip!="%s"`,
+		"domain":   `domain.suffix!="%s"`,
+		"port":     `ip.port!="%s"`,
+		"title":    `web.title!="%s"`,
+		"body":     `web.body!="%s"`,
+		"header":   `header!="%s"`,
+		"cert":     `cert!="%s"`,
+		"icon.md5": `web.icon!="%s"`,
+		"org":      `as.org!="%s"`,
+		"asn":      `as.number!="%s"`,
+		"country":  `ip.country!="%s"`,
+	},
+	And:    " && ",
+	Or:     " || ",
+	Escape: query.QuoteEscape,
 }
 
 func (f Hunter) Name() string {
 	return "hunter"
 }
 
-func (f Hunter) Query(session *sources.Session, query interface{}) (chan sources.Result, error) {
-	apikey := config.RandomKey(f.Name())
-	if apikey == nil {
+func (f Hunter) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	if session.KeyPool(f.Name()).Len() == 0 {
 		return nil, fmt.Errorf("empty %s keys", f.Name())
 	}
-	f.apikey = apikey.(string)
 	f.session = session
 	f.results = make(chan sources.Result)
 
-	// 查询总时长限制10分钟
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-
 	k := query.(Keyword)
+	f.domainMode = k.DomainMode
 	go func() {
 		defer close(f.results)
-
-		for _, ip := range k.IP {
-			f.QueryIP(ctx, ip)
-		}
-		for _, domain := range k.Domain {
-			f.QueryDomain(ctx, domain)
-		}
-		for _, q := range k.Icon {
-			f.QueryIcon(ctx, q.Md5)
-		}
-		for _, cert := range k.Cert {
-			f.QueryCert(ctx, cert)
-		}
+		dispatch(ctx, f, k, iconMd5, f.search)
 	}()
 
 	return f.results, nil
@@ -71,7 +92,13 @@ func (f Hunter) QueryDomain(ctx context.Context, domain string) {
 	if len(domain) == 0 {
 		return
 	}
+	domain, exact := domainScope(domain, f.domainMode)
+	// hunter的domain=为模糊匹配，精确匹配使用==，并在结果中按域名过滤
+	f.scope, f.exact = domain, exact
 	query := fmt.Sprintf(`domain.suffix="%s"`, domain)
+	if exact {
+		query = fmt.Sprintf(`domain=="%s"`, domain)
+	}
 	f.search(ctx, query)
 }
 
@@ -87,7 +114,25 @@ func (f Hunter) QueryCert(ctx context.Context, keyword string) {
 	if len(keyword) == 0 {
 		return
 	}
-	query := fmt.Sprintf(`cert="%s"`, keyword)
+	query := fmt.Sprintf(`cert="%s"`, hunterDialect.EscapeValue(keyword))
+	f.search(ctx, query)
+}
+
+func (f Hunter) QueryExpr(ctx context.Context, expr query.Node) {
+	q, err := hunterDialect.Translate(expr)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	f.search(ctx, q)
+}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f Hunter) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	query := fmt.Sprintf(`cert.sha-256="%s"`, c.SHA256)
 	f.search(ctx, query)
 }
 
@@ -113,42 +158,76 @@ type HunterResponse struct {
 	Msg string `json:"message"`
 }
 
+// consumeQuota 解析本次消耗积分，如"消耗积分：20"，解析失败时按结果数计
+func (r *HunterResponse) consumeQuota() int {
+	if n, ok := parseQuota(r.Data.ConsumeQuota); ok {
+		return n
+	}
+	return len(r.Data.Arr)
+}
+
 func (f Hunter) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
+	// 查询结束后输出最后使用的key的剩余积分
+	var last *HunterResponse
+	var lastID string
+	defer func() {
+		if last != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Account: last.account(lastID)})
+		}
+	}()
+	// 按页码分页，中途改变每页数量会打乱偏移，因此在查询开始时按剩余结果数及积分确定
+	size := pageSize(budget, HunterSize, true)
 	page := 1
 	for {
 		base64Query := base64.URLEncoding.EncodeToString([]byte(query))
-		req := &sources.Req{
-			Schema:   "https",
-			Endpoint: "hunter.qianxin.com",
-			Path:     "/openApi/search",
-			Method:   "GET",
-			Header:   map[string]string{"Accept": "application/json"},
-			Query: fmt.Sprintf("api-key=%s&search=%s&page=%d&page_size=%d",
-				f.apikey, base64Query, page, HunterSize),
+		build := func(key interface{}) *sources.Req {
+			apikey, _ := key.(string)
+			return &sources.Req{
+				Schema:   "https",
+				Endpoint: "hunter.qianxin.com",
+				Path:     "/openApi/search",
+				Method:   "GET",
+				Header:   map[string]string{"Accept": "application/json"},
+				Query: fmt.Sprintf("api-key=%s&search=%s&page=%d&page_size=%d",
+					apikey, base64Query, page, size),
+			}
 		}
 
-		request, err := req.Request()
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
-			return
-		}
-		resp, err := f.session.Do(request, f.Name())
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		if sources.IsPlanning(ctx) {
+			sendPlan(ctx, f.results, f.Name(), query, build(nil))
 			return
 		}
-
-		hunterResponse := &HunterResponse{}
-		if err := json.NewDecoder(resp.Body).Decode(hunterResponse); err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		if err := budget.TakePage(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
 			return
 		}
-		if hunterResponse.Code != 200 {
-			f.results <- sources.Result{Source: f.Name(), Error: fmt.Errorf(hunterResponse.Msg)}
+		var hunterResponse *HunterResponse
+		key, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+			hunterResponse = &HunterResponse{}
+			if err := json.NewDecoder(resp.Body).Decode(hunterResponse); err != nil {
+				return err
+			}
+			if hunterResponse.Code != 200 {
+				return hunterError(hunterResponse.Code, hunterResponse.Msg)
+			}
+			return nil
+		})
+		if err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
 			return
 		}
+		budget.Spend(hunterResponse.consumeQuota())
+		last, lastID = hunterResponse, f.session.KeyPool(f.Name()).ID(key)
 
 		for _, res := range hunterResponse.Data.Arr {
+			if len(f.scope) > 0 && !inDomainScope(res.Domain, f.scope, f.exact) {
+				continue
+			}
+			if err := budget.TakeResult(); err != nil {
+				send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+				return
+			}
 			result := sources.Result{Source: f.Name()}
 			result.IP = res.IP
 			result.Port = fmt.Sprintf("%d/%s", res.Port, res.BaseProtocol)
@@ -163,10 +242,12 @@ func (f Hunter) search(ctx context.Context, query string) {
 				result.LastUpdate = parsedTime.Format(time.DateTime)
 			}
 			result.Prompt = query
-			f.results <- result
+			if !send(ctx, f.results, result) {
+				return
+			}
 		}
 
-		if len(hunterResponse.Data.Arr) < HunterSize || hunterResponse.Data.Total == 0 {
+		if len(hunterResponse.Data.Arr) < size || hunterResponse.Data.Total == 0 {
 			return
 		}
 
@@ -179,6 +260,56 @@ func (f Hunter) search(ctx context.Context, query string) {
 	}
 }
 
+// hunterError 按错误码及错误信息分类
+func hunterError(code int, msg string) error {
+	kind := messageKind(msg)
+	switch code {
+	case 401:
+		kind = sources.KindAuth
+	case 429:
+		kind = sources.KindRateLimit
+	}
+	return sources.NewEngineError(kind, "hunter", strconv.Itoa(code), msg)
+}
+
+// account 根据查询返回的积分信息生成账户信息
+func (r *HunterResponse) account(id string) *sources.Account {
+	account := &sources.Account{Source: "hunter", Key: id, Plan: r.Data.AccountType, Remaining: -1}
+	if n, ok := parseQuota(r.Data.RestQuota); ok {
+		account.Remaining = n
+	}
+	return account
+}
+
+// AccountInfo hunter未提供账户信息接口，通过一次无结果的查询获取剩余积分，无结果时不扣除积分
+func (f Hunter) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	var accounts []sources.Account
+	for _, key := range config.Keys(f.Name()) {
+		apikey := key.(string)
+		id := session.KeyPool(f.Name()).ID(key)
+		account := &sources.Account{Source: f.Name(), Key: id, Remaining: -1}
+		req := &sources.Req{
+			Schema:   "https",
+			Endpoint: "hunter.qianxin.com",
+			Path:     "/openApi/search",
+			Method:   "GET",
+			Header:   map[string]string{"Accept": "application/json"},
+			Query: fmt.Sprintf("api-key=%s&search=%s&page=1&page_size=1",
+				apikey, base64.URLEncoding.EncodeToString([]byte(`ip="0.0.0.0"`))),
+		}
+		resp := &HunterResponse{}
+		if err := getJSON(ctx, session, f.Name(), req, resp); err != nil {
+			account.Error = err
+		} else if resp.Code != 200 {
+			account.Error = hunterError(resp.Code, resp.Msg)
+		} else {
+			account = resp.account(id)
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts
+}
+
 func init() {
 	registerPlugin("hunter", Hunter{})
 }
@@ -0,0 +1,144 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/404tk/cmap/query"
+	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
+	"github.com/404tk/cmap/utils"
+)
+
+// InternetDB 基于Shodan免费的InternetDB接口查询单个IP，无需key
+type InternetDB struct {
+	session *sources.Session
+	results chan sources.Result
+}
+
+func (f InternetDB) Name() string {
+	return "internetdb"
+}
+
+func (f InternetDB) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	f.session = session
+	f.results = make(chan sources.Result)
+
+	k := query.(Keyword)
+	go func() {
+		defer close(f.results)
+		dispatch(ctx, f, k, iconMd5, f.search)
+	}()
+
+	return f.results, nil
+}
+
+func (f InternetDB) QueryIP(ctx context.Context, ip string) {
+	if len(ip) == 0 {
+		return
+	}
+	ips, err := utils.ExpandCIDR(ip)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	for _, i := range ips {
+		if ctx.Err() != nil {
+			return
+		}
+		f.search(ctx, i)
+	}
+}
+
+// QueryDomain InternetDB仅支持IP查询
+func (f InternetDB) QueryDomain(ctx context.Context, domain string) {}
+
+// QueryIcon InternetDB仅支持IP查询
+func (f InternetDB) QueryIcon(ctx context.Context, hash string) {}
+
+// QueryCert InternetDB仅支持IP查询
+func (f InternetDB) QueryCert(ctx context.Context, keyword string) {}
+
+// QueryExpr InternetDB仅支持IP查询
+func (f InternetDB) QueryExpr(ctx context.Context, expr query.Node) {}
+
+// QueryCertInfo InternetDB仅支持IP查询
+func (f InternetDB) QueryCertInfo(ctx context.Context, c *cert.Info) {}
+
+type InternetDBResponse struct {
+	IP        string   `json:"ip"`
+	Ports     []int    `json:"ports"`
+	Hostnames []string `json:"hostnames"`
+	Cpes      []string `json:"cpes"`
+	Tags      []string `json:"tags"`
+	Vulns     []string `json:"vulns"`
+}
+
+func (f InternetDB) search(ctx context.Context, ip string) {
+	budget := f.session.Budget(f.Name())
+	req := &sources.Req{
+		Schema:   "https",
+		Endpoint: "internetdb.shodan.io",
+		Path:     "/" + ip,
+		Method:   "GET",
+		Header:   map[string]string{"Accept": "application/json"},
+	}
+	if sources.IsPlanning(ctx) {
+		sendCountPlan(ctx, f.results, f.Name(), ip, req, -1, 1, 0)
+		return
+	}
+	if err := budget.TakePage(); err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: ip, Error: err})
+		return
+	}
+	request, err := req.Request(ctx)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	resp, err := f.session.Do(request, f.Name())
+	if err != nil {
+		// 404表示该IP无数据
+		if sources.KindOf(err) == sources.KindNoResults {
+			return
+		}
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	defer resp.Body.Close()
+
+	response := &InternetDBResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: sources.Classify(f.Name(), err)})
+		return
+	}
+
+	for _, port := range response.Ports {
+		if err := budget.TakeResult(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: ip, Error: err})
+			return
+		}
+		result := sources.Result{Source: f.Name()}
+		result.IP = response.IP
+		result.Port = fmt.Sprintf("%d/tcp", port)
+		result.Host = response.Hostnames
+		result.Fingerprint = strings.Join(response.Cpes, ",")
+		result.Tags = response.Tags
+		result.Vulns = response.Vulns
+		result.Prompt = ip
+		if !send(ctx, f.results, result) {
+			return
+		}
+	}
+}
+
+// AccountInfo internetdb无需key
+func (f InternetDB) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	return nil
+}
+
+func init() {
+	registerPlugin("internetdb", InternetDB{})
+}
@@ -0,0 +1,83 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/404tk/cmap/sources"
+)
+
+// doWithKeys 从key池中取key发送请求并由decode解析响应，
+// key无效或额度耗尽时隔离该key，通过results告知调用方后用下一个key重试，
+// 响应体中返回请求过于频繁时退避后重试，返回最后使用的key
+func doWithKeys(ctx context.Context, session *sources.Session, results chan<- sources.Result, source string,
+	build func(key interface{}) *sources.Req, decode func(*http.Response) error) (interface{}, error) {
+	pool := session.KeyPool(source)
+	attempt := 0
+	for {
+		key, err := pool.Get()
+		if err != nil {
+			return nil, err
+		}
+		request, err := build(key).Request(ctx)
+		if err != nil {
+			return key, err
+		}
+		resp, err := session.DoWithKey(request, source, key)
+		if err == nil {
+			err = decode(resp)
+			resp.Body.Close()
+			if !errors.Is(err, errStop) {
+				err = withKeyID(sources.Classify(source, err), pool.ID(key))
+			}
+		}
+		if errors.Is(err, sources.ErrKeyUnusable) {
+			pool.Quarantine(key, err)
+			if !send(ctx, results, sources.Result{Source: source, Error: fmt.Errorf("key disabled for this run: %w", err)}) {
+				return key, ctx.Err()
+			}
+			continue
+		}
+		// HTTP 429已由Session按重试策略重试，这里只对响应体中的频率错误退避
+		var statusErr *sources.StatusError
+		if errors.Is(err, sources.ErrTooFrequent) && !errors.As(err, &statusErr) && session.Backoff(ctx, attempt) {
+			attempt++
+			continue
+		}
+		return key, err
+	}
+}
+
+// messageKind 根据引擎返回的错误信息判断错误类型
+func messageKind(msg string) sources.ErrorKind {
+	lower := strings.ToLower(msg)
+	hints := []struct {
+		kind  sources.ErrorKind
+		hints []string
+	}{
+		{sources.KindRateLimit, []string{"频繁", "频率", "too many", "too frequent"}},
+		{sources.KindQuota, []string{"积分", "余额不足", "quota", "credits"}},
+		{sources.KindAuth, []string{"token", "凭证", "令牌", "api key", "api-key", "unauthorized", "login"}},
+		{sources.KindSyntax, []string{"语法", "syntax"}},
+	}
+	for _, h := range hints {
+		for _, hint := range h.hints {
+			if strings.Contains(lower, hint) {
+				return h.kind
+			}
+		}
+	}
+	return sources.KindUnknown
+}
+
+// withKeyID 为引擎错误补充key标识
+func withKeyID(err error, id string) error {
+	var e *sources.EngineError
+	if errors.As(err, &e) && len(e.KeyID) == 0 {
+		e.KeyID = id
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/projectdiscovery/ratelimit"
+
+	"github.com/404tk/cmap/options"
+	"github.com/404tk/cmap/sources"
+)
+
+func TestDoWithKeysQuarantine(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") == "revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errmsg":"invalid key"}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+	session, err := sources.NewSession(&options.Options{
+		Agents:    []string{"fofa"},
+		Timeout:   5,
+		Endpoints: map[string]string{"fofa": srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	pool, err := sources.NewKeyPool("fofa", []interface{}{"revoked", "valid"}, sources.KeyRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Keys["fofa"] = pool
+	for _, id := range []string{"fofa#0", "fofa#1"} {
+		if err := session.RateLimits.Add(&ratelimit.Options{Key: id, IsUnlimited: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := make(chan sources.Result, 1)
+	build := func(key interface{}) *sources.Req {
+		return &sources.Req{Schema: "https", Endpoint: "fofa.info", Path: "/", Method: "GET", Query: fmt.Sprintf("key=%s", key)}
+	}
+	key, err := doWithKeys(context.Background(), session, results, "fofa", build, func(*http.Response) error { return nil })
+	if err != nil || key != "valid" {
+		t.Fatalf("doWithKeys() = %v, %v, want valid", key, err)
+	}
+	select {
+	case result := <-results:
+		var e *sources.EngineError
+		if !errors.As(result.Error, &e) || e.KeyID != "fofa#0" || !errors.Is(result.Error, sources.ErrKeyUnusable) {
+			t.Errorf("result error = %v, want unusable key fofa#0", result.Error)
+		}
+	default:
+		t.Fatal("no result reported for the disabled key")
+	}
+	if n := session.Stats()["fofa"].Quarantined; n != 1 {
+		t.Errorf("Stats().Quarantined = %d, want 1", n)
+	}
+}
@@ -0,0 +1,152 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/404tk/cmap/query"
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	md5Regexp  = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+	mmh3Regexp = regexp.MustCompile(`^-?[0-9]{1,10}$`)
+)
+
+// DomainMatch 域名匹配方式
+type DomainMatch int
+
+const (
+	// DomainSubdomains 匹配域名本身及其所有子域名（默认）
+	DomainSubdomains DomainMatch = iota
+	// DomainExact 仅匹配域名本身
+	DomainExact
+	// DomainRoot 匹配域名所属的根域名及其所有子域名
+	DomainRoot
+)
+
+// ParseDomainMatch 解析域名匹配方式：sub、exact、root
+func ParseDomainMatch(s string) (DomainMatch, bool) {
+	switch strings.ToLower(s) {
+	case "", "sub":
+		return DomainSubdomains, true
+	case "exact":
+		return DomainExact, true
+	case "root":
+		return DomainRoot, true
+	}
+	return DomainSubdomains, false
+}
+
+// domainScope 返回实际查询的域名及是否仅匹配域名本身，根域名模式转换为根域名的子域名匹配
+func domainScope(domain string, mode DomainMatch) (string, bool) {
+	switch mode {
+	case DomainExact:
+		return domain, true
+	case DomainRoot:
+		if root, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+			return root, false
+		}
+	}
+	return domain, false
+}
+
+// inDomainScope 判断host是否在查询域名范围内
+func inDomainScope(host, domain string, exact bool) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == domain {
+		return true
+	}
+	return !exact && strings.HasSuffix(host, "."+domain)
+}
+
+// Icon 图标hash，md5用于quake、hunter，mmh3用于fofa、shodan
+type Icon struct {
+	Md5  string
+	Mmh3 string
+}
+
+// Add 根据格式自动识别目标类型：IP/CIDR、域名、图标md5，其余视为证书关键字。
+// 纯数字无法区分端口与mmh3，图标mmh3需加 icon: 前缀，例如 icon:-247388890
+func (k *Keyword) Add(target string) error {
+	target = strings.TrimSpace(target)
+	if hash, ok := strings.CutPrefix(target, "icon:"); ok {
+		switch {
+		case md5Regexp.MatchString(hash):
+			k.Icon = append(k.Icon, Icon{Md5: strings.ToLower(hash)})
+		case mmh3Regexp.MatchString(hash):
+			k.Icon = append(k.Icon, Icon{Mmh3: hash})
+		default:
+			return fmt.Errorf("invalid icon hash %q", hash)
+		}
+		return nil
+	}
+	switch {
+	case len(target) == 0:
+	case net.ParseIP(target) != nil:
+		k.IP = append(k.IP, target)
+	case strings.Contains(target, "/"):
+		if _, _, err := net.ParseCIDR(target); err == nil {
+			k.IP = append(k.IP, target)
+		} else {
+			k.Cert = append(k.Cert, target)
+		}
+	case md5Regexp.MatchString(target):
+		k.Icon = append(k.Icon, Icon{Md5: strings.ToLower(target)})
+	case mmh3Regexp.MatchString(target):
+		return fmt.Errorf("ambiguous target %q, use icon:%s for an icon mmh3", target, target)
+	case isDomain(target):
+		k.Domain = append(k.Domain, strings.ToLower(target))
+	default:
+		k.Cert = append(k.Cert, target)
+	}
+	return nil
+}
+
+// Validate 校验并规范化查询关键字，IDN域名转换为punycode，
+// 返回所有无效关键字的错误，应在发起任何请求前调用。空值与插件一致直接忽略
+func (k *Keyword) Validate() error {
+	var errs []error
+	for _, ip := range k.IP {
+		if len(ip) == 0 || net.ParseIP(ip) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(ip); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ip %q", ip))
+		}
+	}
+	for i, domain := range k.Domain {
+		if len(domain) == 0 {
+			continue
+		}
+		ascii, ok := query.NormalizeDomain(domain)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid domain %q", domain))
+			continue
+		}
+		k.Domain[i] = ascii
+	}
+	for _, icon := range k.Icon {
+		if len(icon.Md5) > 0 && !md5Regexp.MatchString(icon.Md5) {
+			errs = append(errs, fmt.Errorf("invalid icon md5 %q", icon.Md5))
+		}
+		if len(icon.Mmh3) > 0 && !mmh3Regexp.MatchString(icon.Mmh3) {
+			errs = append(errs, fmt.Errorf("invalid icon mmh3 %q", icon.Mmh3))
+		}
+	}
+	for _, cert := range k.Cert {
+		if strings.ContainsFunc(cert, unicode.IsControl) {
+			errs = append(errs, fmt.Errorf("invalid certificate keyword %q", cert))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isDomain(s string) bool {
+	_, ok := query.NormalizeDomain(s)
+	return ok
+}
@@ -0,0 +1,37 @@
+package plugins
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeywordAdd(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    Keyword
+		wantErr bool
+	}{
+		{target: "10.0.0.1", want: Keyword{IP: []string{"10.0.0.1"}}},
+		{target: "10.0.0.0/8", want: Keyword{IP: []string{"10.0.0.0/8"}}},
+		{target: "Example.com", want: Keyword{Domain: []string{"example.com"}}},
+		{target: "D41D8CD98F00B204E9800998ECF8427E", want: Keyword{Icon: []Icon{{Md5: "d41d8cd98f00b204e9800998ecf8427e"}}}},
+		{target: "icon:-247388890", want: Keyword{Icon: []Icon{{Mmh3: "-247388890"}}}},
+		{target: "icon:d41d8cd98f00b204e9800998ecf8427e", want: Keyword{Icon: []Icon{{Md5: "d41d8cd98f00b204e9800998ecf8427e"}}}},
+		{target: "Example Inc.", want: Keyword{Cert: []string{"Example Inc."}}},
+		{target: "8080", wantErr: true},
+		{target: "-247388890", wantErr: true},
+		{target: "icon:abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var k Keyword
+			err := k.Add(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Add(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(k, tt.want) {
+				t.Errorf("Add(%q) = %+v, want %+v", tt.target, k, tt.want)
+			}
+		})
+	}
+}
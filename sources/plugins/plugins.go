@@ -4,26 +4,39 @@ import (
 	"context"
 	"log"
 
+	"github.com/404tk/cmap/query"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
 )
 
 type Keyword struct {
 	IP     []string
 	Domain []string
-	Icon   []struct {
-		Md5  string
-		Mmh3 string
-	}
-	Cert []string
+	// DomainMode 域名匹配方式，各引擎统一语义
+	DomainMode DomainMatch
+	Icon       []Icon
+	Cert       []string
+	// CertInfo 从证书文件或TLS服务中提取的证书信息，按各引擎支持的字段精确查询
+	CertInfo []*cert.Info
+	// Expr 通用查询语法，由各引擎转换为自身语法
+	Expr []query.Node
+	// Raw 按引擎名称指定的原生查询语句，直接交由引擎查询
+	Raw map[string][]string
+	// History 查询历史数据（目前仅shodan主机查询支持）
+	History bool
 }
 
 type Plugin interface {
 	Name() string
-	Query(*sources.Session, interface{}) (chan sources.Result, error)
+	Query(context.Context, *sources.Session, interface{}) (chan sources.Result, error)
 	QueryIP(context.Context, string)
 	QueryDomain(context.Context, string)
 	QueryIcon(context.Context, string)
 	QueryCert(context.Context, string)
+	QueryExpr(context.Context, query.Node)
+	QueryCertInfo(context.Context, *cert.Info)
+	// AccountInfo 查询配置的全部key的账户信息及剩余额度，无需key的引擎返回nil
+	AccountInfo(context.Context, *sources.Session) []sources.Account
 }
 
 var Plugins = make(map[string]Plugin)
@@ -34,3 +47,95 @@ func registerPlugin(pName string, p Plugin) {
 	}
 	Plugins[pName] = p
 }
+
+// dispatch 按关键字类型依次调用插件的查询方法，icon选择引擎使用的图标hash，raw执行引擎的原生查询。
+// 每个关键字查询前检查ctx，ctx结束后不再查询剩余关键字
+func dispatch(ctx context.Context, p Plugin, k Keyword, icon func(Icon) string, raw func(context.Context, string)) {
+	var tasks []func()
+	for _, ip := range k.IP {
+		ip := ip
+		tasks = append(tasks, func() { p.QueryIP(ctx, ip) })
+	}
+	for _, domain := range k.Domain {
+		domain := domain
+		tasks = append(tasks, func() { p.QueryDomain(ctx, domain) })
+	}
+	for _, i := range k.Icon {
+		hash := icon(i)
+		tasks = append(tasks, func() { p.QueryIcon(ctx, hash) })
+	}
+	for _, c := range k.Cert {
+		c := c
+		tasks = append(tasks, func() { p.QueryCert(ctx, c) })
+	}
+	for _, c := range k.CertInfo {
+		c := c
+		tasks = append(tasks, func() { p.QueryCertInfo(ctx, c) })
+	}
+	for _, expr := range k.Expr {
+		expr := expr
+		tasks = append(tasks, func() { p.QueryExpr(ctx, expr) })
+	}
+	for _, q := range k.Raw[p.Name()] {
+		q := q
+		tasks = append(tasks, func() { raw(ctx, q) })
+	}
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		task()
+	}
+}
+
+// iconMd5 quake、hunter、censys使用图标的md5
+func iconMd5(i Icon) string { return i.Md5 }
+
+// iconMmh3 fofa、shodan使用图标的mmh3
+func iconMmh3(i Icon) string { return i.Mmh3 }
+
+// send 将结果写入通道并隐去错误信息中的key，ctx结束时放弃写入并返回false
+func send(ctx context.Context, results chan<- sources.Result, result sources.Result) bool {
+	result.Error = sources.RedactError(result.Error)
+	select {
+	case <-ctx.Done():
+		return false
+	case results <- result:
+		return true
+	}
+}
+
+// pageSize 返回不超过剩余结果数的每页数量，perResult表示引擎按结果数计费，此时同时不超过剩余额度，
+// 避免只需要少量结果时仍按最大数量请求并扣费
+func pageSize(budget *sources.Budget, max int, perResult bool) int {
+	results, quota := budget.Headroom()
+	size := max
+	if results >= 0 && results < size {
+		size = results
+	}
+	if perResult && quota >= 0 && quota < size {
+		size = quota
+	}
+	if size < 1 {
+		// 额度已用尽，由TakePage返回错误
+		return 1
+	}
+	return size
+}
+
+// sendPlan dry-run时记录查询计划，额度未知的引擎页数及消耗均为-1
+func sendPlan(ctx context.Context, results chan<- sources.Result, source, query string, req *sources.Req) bool {
+	return sendCountPlan(ctx, results, source, query, req, -1, -1, -1)
+}
+
+func sendCountPlan(ctx context.Context, results chan<- sources.Result, source, query string, req *sources.Req, total, pages, cost int) bool {
+	plan := &sources.Plan{
+		Source:   source,
+		Query:    query,
+		Endpoint: req.URL(),
+		Total:    total,
+		Pages:    pages,
+		Cost:     cost,
+	}
+	return send(ctx, results, sources.Result{Source: source, Prompt: query, Plan: plan})
+}
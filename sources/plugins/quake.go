@@ -4,10 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/404tk/cmap/query"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
 	"github.com/404tk/cmap/sources/config"
 )
 
@@ -16,44 +19,47 @@ const (
 )
 
 type Quake struct {
-	apikey  string
-	session *sources.Session
-	results chan sources.Result
+	domainMode DomainMatch
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+var quakeDialect = &query.Dialect{
+	Fields: map[string]string{
+		"ip":       `ip:"%s"`,
+		"domain":   `domain:"%s"`,
+		"port":     `port:%s`,
+		"title":    `title:"%s"`,
+		"body":     `response:"%s"`,
+		"header":   `headers:"%s"`,
+		"cert":     `cert:"%s"`,
+		"icon.md5": `favicon:"%s"`,
+		"org":      `org:"%s"`,
+		"asn":      `asn:%s`,
+		"country":  `country:"%s"`,
+	},
+	And:    " AND ",
+	Or:     " OR ",
+	Not:    "NOT ",
+	Escape: query.QuoteEscape,
 }
 
 func (f Quake) Name() string {
 	return "quake"
 }
 
-func (f Quake) Query(session *sources.Session, query interface{}) (chan sources.Result, error) {
-	apikey := config.RandomKey(f.Name())
-	if apikey == nil {
+func (f Quake) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	if session.KeyPool(f.Name()).Len() == 0 {
 		return nil, fmt.Errorf("empty %s keys", f.Name())
 	}
-	f.apikey = apikey.(string)
 	f.session = session
 	f.results = make(chan sources.Result)
 
-	// 查询总时长限制10分钟
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-
 	k := query.(Keyword)
+	f.domainMode = k.DomainMode
 	go func() {
 		defer close(f.results)
-
-		for _, ip := range k.IP {
-			f.QueryIP(ctx, ip)
-		}
-		for _, domain := range k.Domain {
-			f.QueryDomain(ctx, domain)
-		}
-		for _, q := range k.Icon {
-			f.QueryIcon(ctx, q.Md5)
-		}
-		for _, cert := range k.Cert {
-			f.QueryCert(ctx, cert)
-		}
+		dispatch(ctx, f, k, iconMd5, f.search)
 	}()
 
 	return f.results, nil
@@ -71,7 +77,11 @@ func (f Quake) QueryDomain(ctx context.Context, domain string) {
 	if len(domain) == 0 {
 		return
 	}
-	query := fmt.Sprintf(`domain:"*.%s"`, domain)
+	domain, exact := domainScope(domain, f.domainMode)
+	query := fmt.Sprintf(`domain:"%s" OR domain:"*.%s"`, domain, domain)
+	if exact {
+		query = fmt.Sprintf(`domain:"%s"`, domain)
+	}
 	f.search(ctx, query)
 }
 
@@ -87,7 +97,25 @@ func (f Quake) QueryCert(ctx context.Context, keyword string) {
 	if len(keyword) == 0 {
 		return
 	}
-	query := fmt.Sprintf(`cert:"%s"`, keyword)
+	query := fmt.Sprintf(`cert:"%s"`, quakeDialect.EscapeValue(keyword))
+	f.search(ctx, query)
+}
+
+func (f Quake) QueryExpr(ctx context.Context, expr query.Node) {
+	q, err := quakeDialect.Translate(expr)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	f.search(ctx, q)
+}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f Quake) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	query := fmt.Sprintf(`cert:"%s"`, c.Serial)
 	f.search(ctx, query)
 }
 
@@ -108,45 +136,55 @@ func (req *QuakeRequest) toString() string {
 }
 
 func (f Quake) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
 	numberOfResults := 0
 	for {
+		// 按偏移分页，每页按剩余结果数及积分确定数量
+		size := pageSize(budget, QuakeSize, true)
 		quakeRequest := &QuakeRequest{
 			Query:       query,
-			Size:        QuakeSize,
+			Size:        size,
 			Start:       numberOfResults,
 			IgnoreCache: true,
 			Include:     []string{"ip", "port", "hostname", "transport", "service.name", "service.http.host", "service.http.title"},
 		}
-		req := &sources.Req{
-			Schema:   "https",
-			Endpoint: "quake.360.net",
-			Path:     "/api/v3/search/quake_service",
-			Method:   "POST",
-			Header: map[string]string{
-				"Content-Type": "application/json",
-				"X-QuakeToken": f.apikey,
-			},
-			Body: quakeRequest.toString(),
+		build := func(key interface{}) *sources.Req {
+			apikey, _ := key.(string)
+			return &sources.Req{
+				Schema:   "https",
+				Endpoint: "quake.360.net",
+				Path:     "/api/v3/search/quake_service",
+				Method:   "POST",
+				Header: map[string]string{
+					"Content-Type": "application/json",
+					"X-QuakeToken": apikey,
+				},
+				Body:       quakeRequest.toString(),
+				Idempotent: true,
+			}
 		}
 
-		request, err := req.Request()
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
-			return
-		}
-		resp, err := f.session.Do(request, f.Name())
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		if sources.IsPlanning(ctx) {
+			sendPlan(ctx, f.results, f.Name(), query, build(nil))
 			return
 		}
-
-		response := &QuakeResponse{}
-		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		if err := budget.TakePage(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
 			return
 		}
-		if c, _ := json.Marshal(response.Code); string(c) != "0" {
-			f.results <- sources.Result{Source: f.Name(), Error: fmt.Errorf(response.Message)}
+		var response *QuakeResponse
+		_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+			response = &QuakeResponse{}
+			if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+				return err
+			}
+			if quakeCode(response.Code) != "0" {
+				return quakeError(response.Code, response.Message)
+			}
+			return nil
+		})
+		if err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
 			return
 		}
 
@@ -166,11 +204,16 @@ func (f Quake) search(ctx context.Context, query string) {
 		d, _ := json.Marshal(response.Data)
 		var data []quakeData
 		if err := json.Unmarshal(d, &data); err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: fmt.Errorf("wrong format")}
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: sources.NewEngineError(sources.KindDecode, f.Name(), "", "wrong format")})
 			return
 		}
+		budget.Spend(len(data))
 
 		for _, res := range data {
+			if err := budget.TakeResult(); err != nil {
+				send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+				return
+			}
 			result := sources.Result{Source: f.Name()}
 			result.IP = res.IP
 			result.Port = fmt.Sprintf("%d/%s", res.Port, res.Transport)
@@ -187,10 +230,12 @@ func (f Quake) search(ctx context.Context, query string) {
 			}
 			result.Prompt = query
 
-			f.results <- result
+			if !send(ctx, f.results, result) {
+				return
+			}
 		}
 
-		if response.Meta.Pagination.Count < QuakeSize {
+		if response.Meta.Pagination.Count < size {
 			return
 		}
 
@@ -222,6 +267,88 @@ type QuakeResponse struct {
 	} `json:"meta"`
 }
 
+// quakeErrorKinds quake文档中的错误码，u开头为用户相关错误，q开头为查询相关错误
+var quakeErrorKinds = map[string]sources.ErrorKind{
+	"u3004": sources.KindAuth,      // 注册用户无API权限
+	"u3007": sources.KindAuth,      // 账号被封禁
+	"u3011": sources.KindQuota,     // 积分不足
+	"u3015": sources.KindRateLimit, // 用户请求过于频繁
+	"u3017": sources.KindAuth,      // 无权限使用该接口
+	"t6003": sources.KindAuth,      // Token无效
+	"q2001": sources.KindSyntax,    // 查询语法错误
+	"q3005": sources.KindRateLimit, // 调用API频率过快
+	"q3015": sources.KindQuota,     // 当月可查询数据量已用完
+}
+
+// quakeCode 统一错误码的格式，quake的错误码为字符串或数字，成功时为0
+func quakeCode(code interface{}) string {
+	switch c := code.(type) {
+	case string:
+		return c
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	}
+	return fmt.Sprint(code)
+}
+
+// quakeError 按错误码分类，未知错误码按错误信息分类
+func quakeError(code interface{}, msg string) error {
+	c := quakeCode(code)
+	kind, ok := quakeErrorKinds[c]
+	if !ok {
+		kind = messageKind(msg)
+	}
+	return sources.NewEngineError(kind, "quake", c, msg)
+}
+
+type QuakeAccountResponse struct {
+	Code    interface{} `json:"code"`
+	Message string      `json:"message"`
+	Data    struct {
+		MonthRemainingCredit int `json:"month_remaining_credit"`
+		ConstantCredit       int `json:"constant_credit"`
+		Role                 []struct {
+			Fullname string `json:"fullname"`
+		} `json:"role"`
+		RoleValidity map[string]interface{} `json:"role_validity"`
+	} `json:"data"`
+}
+
+func (f Quake) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	var accounts []sources.Account
+	for _, key := range config.Keys(f.Name()) {
+		apikey := key.(string)
+		account := sources.Account{Source: f.Name(), Key: session.KeyPool(f.Name()).ID(key), Remaining: -1}
+		req := &sources.Req{
+			Schema:   "https",
+			Endpoint: "quake.360.net",
+			Path:     "/api/v3/user/info",
+			Method:   "GET",
+			Header:   map[string]string{"X-QuakeToken": apikey},
+		}
+		resp := &QuakeAccountResponse{}
+		if err := getJSON(ctx, session, f.Name(), req, resp); err != nil {
+			account.Error = err
+		} else if quakeCode(resp.Code) != "0" {
+			account.Error = quakeError(resp.Code, resp.Message)
+		} else {
+			var roles []string
+			for _, role := range resp.Data.Role {
+				roles = append(roles, role.Fullname)
+				// 会员有效期，未开通会员时为null
+				if v, ok := resp.Data.RoleValidity[role.Fullname].(string); ok {
+					account.Expiry = v
+				}
+			}
+			account.Plan = strings.Join(roles, ",")
+			// 月度积分与长效积分均可用于查询
+			account.Remaining = resp.Data.MonthRemainingCredit + resp.Data.ConstantCredit
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
 func init() {
 	registerPlugin("quake", Quake{})
 }
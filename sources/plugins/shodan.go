@@ -4,10 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"time"
 
+	"github.com/404tk/cmap/query"
 	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
 	"github.com/404tk/cmap/sources/config"
 )
 
@@ -16,44 +20,50 @@ const (
 )
 
 type Shodan struct {
-	apikey  string
-	session *sources.Session
-	results chan sources.Result
+	history    bool
+	domainMode DomainMatch
+	exactHost  string
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+// shodan不支持OR及括号分组
+var shodanDialect = &query.Dialect{
+	Fields: map[string]string{
+		"ip":        `net:"%s"`,
+		"domain":    `hostname:"%s"`,
+		"port":      `port:%s`,
+		"title":     `http.title:"%s"`,
+		"body":      `http.html:"%s"`,
+		"cert":      `ssl:"%s"`,
+		"icon.mmh3": `http.favicon.hash:%s`,
+		"org":       `org:"%s"`,
+		"asn":       `asn:"%s"`,
+		"country":   `country:"%s"`,
+	},
+	And:     " ",
+	Not:     "-",
+	NoGroup: true,
+	Escape:  query.StripQuotes,
 }
 
 func (f Shodan) Name() string {
 	return "shodan"
 }
 
-func (f Shodan) Query(session *sources.Session, query interface{}) (chan sources.Result, error) {
-	apikey := config.RandomKey(f.Name())
-	if apikey == nil {
+func (f Shodan) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	if session.KeyPool(f.Name()).Len() == 0 {
 		return nil, fmt.Errorf("empty %s keys", f.Name())
 	}
-	f.apikey = apikey.(string)
 	f.session = session
 	f.results = make(chan sources.Result)
 
-	// 查询总时长限制10分钟
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-
 	k := query.(Keyword)
+	f.domainMode = k.DomainMode
+	f.history = k.History
 	go func() {
 		defer close(f.results)
-
-		for _, ip := range k.IP {
-			f.QueryIP(ctx, ip)
-		}
-		for _, domain := range k.Domain {
-			f.QueryDomain(ctx, domain)
-		}
-		for _, q := range k.Icon {
-			f.QueryIcon(ctx, q.Mmh3)
-		}
-		for _, cert := range k.Cert {
-			f.QueryCert(ctx, cert)
-		}
+		dispatch(ctx, f, k, iconMmh3, f.search)
 	}()
 
 	return f.results, nil
@@ -63,6 +73,11 @@ func (f Shodan) QueryIP(ctx context.Context, ip string) {
 	if len(ip) == 0 {
 		return
 	}
+	// 单个IP直接查询主机详情，不消耗查询积分
+	if net.ParseIP(ip) != nil {
+		f.host(ctx, ip)
+		return
+	}
 	query := fmt.Sprintf(`net:"%s"`, ip)
 	f.search(ctx, query)
 }
@@ -71,6 +86,11 @@ func (f Shodan) QueryDomain(ctx context.Context, domain string) {
 	if len(domain) == 0 {
 		return
 	}
+	domain, exact := domainScope(domain, f.domainMode)
+	// shodan的hostname包含子域名，精确匹配时在结果中过滤
+	if exact {
+		f.exactHost = domain
+	}
 	query := fmt.Sprintf(`hostname:"%s"`, domain)
 	f.search(ctx, query)
 }
@@ -87,92 +107,235 @@ func (f Shodan) QueryCert(ctx context.Context, keyword string) {
 	if len(keyword) == 0 {
 		return
 	}
-	query := fmt.Sprintf(`ssl:"%s"`, keyword)
+	query := fmt.Sprintf(`ssl:"%s"`, shodanDialect.EscapeValue(keyword))
+	f.search(ctx, query)
+}
+
+func (f Shodan) QueryExpr(ctx context.Context, expr query.Node) {
+	q, err := shodanDialect.Translate(expr)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	f.search(ctx, q)
+}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f Shodan) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	query := fmt.Sprintf(`ssl.cert.serial:%s`, c.Serial)
 	f.search(ctx, query)
 }
 
+type ShodanBanner struct {
+	IP        string   `json:"ip_str"`
+	Port      int      `json:"port"`
+	Transport string   `json:"transport"`
+	Hostname  []string `json:"hostnames"`
+	Product   string   `json:"product"`
+	Http      struct {
+		Host  string `json:"host"`
+		Title string `json:"title"`
+	}
+	SSL struct {
+		Chain []string `json:"chain"`
+	} `json:"ssl"`
+	Timestamp string `json:"timestamp"`
+}
+
 type ShodanResponse struct {
 	Total int `json:"total"`
 	//Results []map[string]interface{} `json:"matches"`
-	Results []struct {
-		IP        string   `json:"ip_str"`
-		Port      int      `json:"port"`
-		Transport string   `json:"transport"`
-		Hostname  []string `json:"hostname"`
-		Product   string   `json:"product"`
-		Http      struct {
-			Host  string `json:"host"`
-			Title string `json:"title"`
-		}
-		SSL struct {
-			Chain []string `json:"chain"`
-		} `json:"ssl"`
-		Timestamp string `json:"timestamp"`
-	} `json:"matches"`
+	Results []ShodanBanner `json:"matches"`
 }
 
-func (f Shodan) search(ctx context.Context, query string) {
-	page := 1
-	var numberOfResults int
-	for {
+// ShodanHostResponse contains the /shodan/host/{ip} response
+type ShodanHostResponse struct {
+	IP   string         `json:"ip_str"`
+	Data []ShodanBanner `json:"data"`
+}
+
+func (f Shodan) toResult(res ShodanBanner, query string) sources.Result {
+	result := sources.Result{Source: f.Name()}
+	result.IP = res.IP
+	result.Port = fmt.Sprintf("%d/%s", res.Port, res.Transport)
+	if len(res.Hostname) > 0 {
+		result.Host = res.Hostname
+	}
+	if len(res.Http.Host) > 0 {
+		result.Title = res.Http.Title
+		if len(res.SSL.Chain) > 0 {
+			result.Protocol = "https"
+			result.Url = fmt.Sprintf("https://%s", result.IpPort())
+		} else {
+			result.Protocol = "http"
+			result.Url = fmt.Sprintf("http://%s", result.IpPort())
+		}
+	}
+	result.Fingerprint = res.Product
+	parsedTime, err := time.Parse(time.RFC3339Nano[:26], res.Timestamp)
+	if err == nil {
+		result.LastUpdate = parsedTime.Format(time.DateTime)
+	}
+	result.Prompt = query
+	return result
+}
+
+func (f Shodan) matchHost(hostnames []string) bool {
+	for _, host := range hostnames {
+		if inDomainScope(host, f.exactHost, true) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Shodan) host(ctx context.Context, ip string) {
+	budget := f.session.Budget(f.Name())
+	query := "/shodan/host/" + ip
+	if f.history {
+		query += "?history=true"
+	}
+	build := func(key interface{}) *sources.Req {
+		apikey, _ := key.(string)
 		req := &sources.Req{
 			Schema:   "https",
 			Endpoint: "api.shodan.io",
-			Path:     "/shodan/host/search",
+			Path:     "/shodan/host/" + ip,
 			Method:   "GET",
 			Header:   map[string]string{"User-Agent": "curl/8.7.1"},
+			Query:    fmt.Sprintf("key=%s", apikey),
 		}
-		req.Query = fmt.Sprintf("key=%s&query=%s&page=%d",
-			f.apikey, url.QueryEscape(query), page)
-		request, err := req.Request()
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		if f.history {
+			req.Query += "&history=true"
+		}
+		return req
+	}
+	// 主机查询不消耗查询积分
+	if sources.IsPlanning(ctx) {
+		sendCountPlan(ctx, f.results, f.Name(), query, build(nil), -1, 1, 0)
+		return
+	}
+	if err := budget.TakePage(); err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+		return
+	}
+	var hostResponse *ShodanHostResponse
+	_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+		hostResponse = &ShodanHostResponse{}
+		return json.NewDecoder(resp.Body).Decode(hostResponse)
+	})
+	if err != nil {
+		// 404表示该IP无数据
+		if sources.KindOf(err) == sources.KindNoResults {
 			return
 		}
-		resp, err := f.session.Do(request, f.Name())
-		if err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+
+	for _, res := range hostResponse.Data {
+		if len(res.IP) == 0 {
+			res.IP = hostResponse.IP
+		}
+		if err := budget.TakeResult(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+			return
+		}
+		if !send(ctx, f.results, f.toResult(res, query)) {
 			return
 		}
+	}
+}
+
+type ShodanCountResponse struct {
+	Total int `json:"total"`
+}
 
-		shodanResponse := &ShodanResponse{}
-		if err := json.NewDecoder(resp.Body).Decode(shodanResponse); err != nil {
-			f.results <- sources.Result{Source: f.Name(), Error: err}
+func (f Shodan) plan(ctx context.Context, query string, search *sources.Req) {
+	build := func(key interface{}) *sources.Req {
+		apikey, _ := key.(string)
+		return &sources.Req{
+			Schema:   "https",
+			Endpoint: "api.shodan.io",
+			Path:     "/shodan/host/count",
+			Method:   "GET",
+			Header:   map[string]string{"User-Agent": "curl/8.7.1"},
+			Query:    fmt.Sprintf("key=%s&query=%s", apikey, url.QueryEscape(query)),
+		}
+	}
+	var countResponse *ShodanCountResponse
+	_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+		countResponse = &ShodanCountResponse{}
+		return json.NewDecoder(resp.Body).Decode(countResponse)
+	})
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	pages := (countResponse.Total + ShodanSize - 1) / ShodanSize
+	sendCountPlan(ctx, f.results, f.Name(), query, search, countResponse.Total, pages, pages)
+}
+
+func (f Shodan) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
+	page := 1
+	var numberOfResults int
+	for {
+		build := func(key interface{}) *sources.Req {
+			apikey, _ := key.(string)
+			return &sources.Req{
+				Schema:   "https",
+				Endpoint: "api.shodan.io",
+				Path:     "/shodan/host/search",
+				Method:   "GET",
+				Header:   map[string]string{"User-Agent": "curl/8.7.1"},
+				Query: fmt.Sprintf("key=%s&query=%s&page=%d",
+					apikey, url.QueryEscape(query), page),
+			}
+		}
+		// 搜索语句通过免费的count接口预估页数，每页消耗1个查询积分
+		if sources.IsPlanning(ctx) {
+			f.plan(ctx, query, build(nil))
+			return
+		}
+		if err := budget.TakePage(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+			return
+		}
+		var shodanResponse *ShodanResponse
+		_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+			shodanResponse = &ShodanResponse{}
+			return json.NewDecoder(resp.Body).Decode(shodanResponse)
+		})
+		if err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
 			return
 		}
+		// 每页消耗1个查询积分
+		budget.Spend(1)
 
 		for _, res := range shodanResponse.Results {
-			result := sources.Result{Source: f.Name()}
 			if len(res.IP) == 0 {
 				continue
 
 			}
-			result.IP = res.IP
-			result.Port = fmt.Sprintf("%d/%s", res.Port, res.Transport)
-			if len(res.Hostname) > 0 {
-				result.Host = res.Hostname
+			if len(f.exactHost) > 0 && !f.matchHost(res.Hostname) {
+				continue
 			}
-			if len(res.Http.Host) > 0 {
-				result.Title = res.Http.Title
-				if len(res.SSL.Chain) > 0 {
-					result.Protocol = "https"
-					result.Url = fmt.Sprintf("https://%s", result.IpPort())
-				} else {
-					result.Protocol = "http"
-					result.Url = fmt.Sprintf("http://%s", result.IpPort())
-				}
+			if err := budget.TakeResult(); err != nil {
+				send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+				return
 			}
-			result.Fingerprint = res.Product
-			parsedTime, err := time.Parse(time.RFC3339Nano[:26], res.Timestamp)
-			if err == nil {
-				result.LastUpdate = parsedTime.Format(time.DateTime)
+			if !send(ctx, f.results, f.toResult(res, query)) {
+				return
 			}
-			result.Prompt = query
-			f.results <- result
 		}
 
 		numberOfResults += len(shodanResponse.Results)
-		if len(shodanResponse.Results) < FofaSize || numberOfResults > shodanResponse.Total {
+		if len(shodanResponse.Results) < ShodanSize || numberOfResults > shodanResponse.Total {
 			return
 		}
 
@@ -185,6 +348,40 @@ func (f Shodan) search(ctx context.Context, query string) {
 	}
 }
 
+type ShodanAccountResponse struct {
+	Plan         string `json:"plan"`
+	QueryCredits int    `json:"query_credits"`
+	Error        string `json:"error"`
+}
+
+func (f Shodan) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	var accounts []sources.Account
+	for _, key := range config.Keys(f.Name()) {
+		apikey := key.(string)
+		account := sources.Account{Source: f.Name(), Key: session.KeyPool(f.Name()).ID(key), Remaining: -1}
+		req := &sources.Req{
+			Schema:   "https",
+			Endpoint: "api.shodan.io",
+			Path:     "/api-info",
+			Method:   "GET",
+			Header:   map[string]string{"User-Agent": "curl/8.7.1"},
+			Query:    fmt.Sprintf("key=%s", apikey),
+		}
+		resp := &ShodanAccountResponse{}
+		if err := getJSON(ctx, session, f.Name(), req, resp); err != nil {
+			account.Error = err
+		} else if len(resp.Error) > 0 {
+			account.Error = sources.NewEngineError(messageKind(resp.Error), f.Name(), "", resp.Error)
+		} else {
+			account.Plan = resp.Plan
+			// query credits每月初重置
+			account.Remaining = resp.QueryCredits
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
 func init() {
 	registerPlugin("shodan", Shodan{})
 }
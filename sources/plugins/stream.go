@@ -0,0 +1,67 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errStop 处理结果时达到限制或ctx结束，停止解析剩余内容
+var errStop = errors.New("stop")
+
+// decodeStream 流式解析JSON对象，field数组中的元素逐个交给each处理，
+// 避免一次性载入大分页，其余字段解析到v中。each返回错误时停止解析
+func decodeStream(r io.Reader, v interface{}, field string, each func(json.RawMessage) error) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("unexpected json token %v", t)
+	}
+	rest := make(map[string]json.RawMessage)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := t.(string)
+		if key != field {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			rest[key] = raw
+			continue
+		}
+
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			continue
+		}
+		if t != json.Delim('[') {
+			return fmt.Errorf("unexpected json token %v of %s", t, field)
+		}
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			if err := each(raw); err != nil {
+				return err
+			}
+		}
+		// 数组结束符
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
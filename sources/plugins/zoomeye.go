@@ -0,0 +1,317 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/404tk/cmap/query"
+	"github.com/404tk/cmap/sources"
+	"github.com/404tk/cmap/sources/cert"
+	"github.com/404tk/cmap/sources/config"
+)
+
+const (
+	ZoomEyeSize = 20
+)
+
+type ZoomEye struct {
+	domainMode DomainMatch
+	session    *sources.Session
+	results    chan sources.Result
+}
+
+var zoomeyeDialect = &query.Dialect{
+	Fields: map[string]string{
+		"ip":        `ip:"%s"`,
+		"ip.cidr":   `cidr:"%s"`,
+		"domain":    `hostname:"%s"`,
+		"port":      `port:%s`,
+		"title":     `title:"%s"`,
+		"cert":      `ssl:"%s"`,
+		"icon.md5":  `iconhash:"%s"`,
+		"icon.mmh3": `iconhash:"%s"`,
+		"org":       `org:"%s"`,
+		"asn":       `asn:%s`,
+		"country":   `country:"%s"`,
+	},
+	And:    " +",
+	AndNot: " -",
+	Or:     " ",
+	Not:    "-",
+	Escape: query.QuoteEscape,
+}
+
+func (f ZoomEye) Name() string {
+	return "zoomeye"
+}
+
+func (f ZoomEye) Query(ctx context.Context, session *sources.Session, query interface{}) (chan sources.Result, error) {
+	if session.KeyPool(f.Name()).Len() == 0 {
+		return nil, fmt.Errorf("empty %s keys", f.Name())
+	}
+	f.session = session
+	f.results = make(chan sources.Result)
+
+	k := query.(Keyword)
+	f.domainMode = k.DomainMode
+	go func() {
+		defer close(f.results)
+		dispatch(ctx, f, k, zoomeyeIcon, f.search)
+	}()
+
+	return f.results, nil
+}
+
+func (f ZoomEye) QueryIP(ctx context.Context, ip string) {
+	if len(ip) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`ip:"%s"`, ip)
+	if strings.Contains(ip, "/") {
+		query = fmt.Sprintf(`cidr:"%s"`, ip)
+	}
+	f.search(ctx, query)
+}
+
+func (f ZoomEye) QueryDomain(ctx context.Context, domain string) {
+	if len(domain) == 0 {
+		return
+	}
+	domain, exact := domainScope(domain, f.domainMode)
+	query := fmt.Sprintf(`site:"%s"`, domain)
+	if exact {
+		query = fmt.Sprintf(`hostname:"%s"`, domain)
+	}
+	f.search(ctx, query)
+}
+
+// zoomeyeIcon iconhash同时支持mmh3及md5，优先使用mmh3
+func zoomeyeIcon(i Icon) string {
+	if len(i.Mmh3) > 0 {
+		return i.Mmh3
+	}
+	return i.Md5
+}
+
+func (f ZoomEye) QueryIcon(ctx context.Context, hash string) {
+	if len(hash) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`iconhash:"%s"`, hash)
+	f.search(ctx, query)
+}
+
+func (f ZoomEye) QueryCert(ctx context.Context, keyword string) {
+	if len(keyword) == 0 {
+		return
+	}
+	query := fmt.Sprintf(`ssl:"%s"`, zoomeyeDialect.EscapeValue(keyword))
+	f.search(ctx, query)
+}
+
+func (f ZoomEye) QueryExpr(ctx context.Context, expr query.Node) {
+	q, err := zoomeyeDialect.Translate(expr)
+	if err != nil {
+		send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+		return
+	}
+	f.search(ctx, q)
+}
+
+// QueryCertInfo 基于证书文件中的精确字段查询
+func (f ZoomEye) QueryCertInfo(ctx context.Context, c *cert.Info) {
+	if c == nil {
+		return
+	}
+	query := fmt.Sprintf(`ssl.cert.fingerprint:"%s"`, strings.ToUpper(c.SHA1))
+	f.search(ctx, query)
+}
+
+type ZoomEyeResponse struct {
+	Total     int    `json:"total"`
+	Available int    `json:"available"`
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Matches   []struct {
+		IP       interface{} `json:"ip"`
+		Rdns     string      `json:"rdns"`
+		PortInfo struct {
+			Port     int         `json:"port"`
+			Service  string      `json:"service"`
+			App      string      `json:"app"`
+			Hostname string      `json:"hostname"`
+			Title    interface{} `json:"title"`
+		} `json:"portinfo"`
+		Protocol struct {
+			Application string `json:"application"`
+			Transport   string `json:"transport"`
+		} `json:"protocol"`
+		Timestamp string `json:"timestamp"`
+	} `json:"matches"`
+}
+
+func (f ZoomEye) search(ctx context.Context, query string) {
+	budget := f.session.Budget(f.Name())
+	page := 1
+	var numberOfResults int
+	for {
+		build := func(key interface{}) *sources.Req {
+			apikey, _ := key.(string)
+			return &sources.Req{
+				Schema:   "https",
+				Endpoint: "api.zoomeye.hk",
+				Path:     "/host/search",
+				Method:   "GET",
+				Header: map[string]string{
+					"Accept":  "application/json",
+					"API-KEY": apikey,
+				},
+				// 接口不支持指定每页数量，固定为20条
+				Query: fmt.Sprintf("query=%s&page=%d", url.QueryEscape(query), page),
+			}
+		}
+
+		if sources.IsPlanning(ctx) {
+			sendPlan(ctx, f.results, f.Name(), query, build(nil))
+			return
+		}
+		if err := budget.TakePage(); err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+			return
+		}
+		// key无效或额度不足时接口返回401、402或403
+		var zoomeyeResponse *ZoomEyeResponse
+		_, err := doWithKeys(ctx, f.session, f.results, f.Name(), build, func(resp *http.Response) error {
+			zoomeyeResponse = &ZoomEyeResponse{}
+			if err := json.NewDecoder(resp.Body).Decode(zoomeyeResponse); err != nil {
+				return err
+			}
+			if len(zoomeyeResponse.Error) > 0 {
+				return sources.NewEngineError(messageKind(zoomeyeResponse.Error+" "+zoomeyeResponse.Message),
+					f.Name(), zoomeyeResponse.Error, zoomeyeResponse.Message)
+			}
+			return nil
+		})
+		if err != nil {
+			send(ctx, f.results, sources.Result{Source: f.Name(), Error: err})
+			return
+		}
+		budget.Spend(len(zoomeyeResponse.Matches))
+
+		for _, res := range zoomeyeResponse.Matches {
+			if err := budget.TakeResult(); err != nil {
+				send(ctx, f.results, sources.Result{Source: f.Name(), Prompt: query, Error: err})
+				return
+			}
+			result := sources.Result{Source: f.Name()}
+			// 域名资产返回的ip为数组
+			switch ip := res.IP.(type) {
+			case string:
+				result.IP = ip
+			case []interface{}:
+				if len(ip) > 0 {
+					result.IP, _ = ip[0].(string)
+				}
+			}
+			if len(result.IP) == 0 {
+				continue
+			}
+			transport := res.Protocol.Transport
+			if len(transport) == 0 {
+				transport = "tcp"
+			}
+			result.Port = fmt.Sprintf("%d/%s", res.PortInfo.Port, transport)
+			result.Protocol = res.PortInfo.Service
+			if len(res.PortInfo.Hostname) > 0 {
+				result.Host = append(result.Host, res.PortInfo.Hostname)
+			}
+			if len(res.Rdns) > 0 && res.Rdns != res.PortInfo.Hostname {
+				result.Host = append(result.Host, res.Rdns)
+			}
+			switch title := res.PortInfo.Title.(type) {
+			case string:
+				result.Title = title
+			case []interface{}:
+				if len(title) > 0 {
+					result.Title, _ = title[0].(string)
+				}
+			}
+			if strings.HasPrefix(result.Protocol, "http") {
+				if result.Protocol == "https" {
+					result.Url = fmt.Sprintf("https://%s", result.IpPort())
+				} else {
+					result.Url = fmt.Sprintf("http://%s", result.IpPort())
+				}
+			}
+			result.Fingerprint = res.PortInfo.App
+			parsedTime, err := time.Parse("2006-01-02T15:04:05", res.Timestamp)
+			if err == nil {
+				result.LastUpdate = parsedTime.Format(time.DateTime)
+			}
+			result.Prompt = query
+			if !send(ctx, f.results, result) {
+				return
+			}
+		}
+
+		numberOfResults += len(zoomeyeResponse.Matches)
+		if len(zoomeyeResponse.Matches) < ZoomEyeSize || numberOfResults >= zoomeyeResponse.Total {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			page++
+		}
+	}
+}
+
+type ZoomEyeAccountResponse struct {
+	Error    string `json:"error"`
+	Message  string `json:"message"`
+	Plan     string `json:"plan"`
+	UserInfo struct {
+		ExpiredAt string `json:"expired_at"`
+	} `json:"user_info"`
+	QuotaInfo struct {
+		RemainTotalQuota int `json:"remain_total_quota"`
+	} `json:"quota_info"`
+}
+
+func (f ZoomEye) AccountInfo(ctx context.Context, session *sources.Session) []sources.Account {
+	var accounts []sources.Account
+	for _, key := range config.Keys(f.Name()) {
+		apikey := key.(string)
+		account := sources.Account{Source: f.Name(), Key: session.KeyPool(f.Name()).ID(key), Remaining: -1}
+		req := &sources.Req{
+			Schema:   "https",
+			Endpoint: "api.zoomeye.hk",
+			Path:     "/resources-info",
+			Method:   "GET",
+			Header:   map[string]string{"API-KEY": apikey},
+		}
+		resp := &ZoomEyeAccountResponse{}
+		if err := getJSON(ctx, session, f.Name(), req, resp); err != nil {
+			account.Error = err
+		} else if len(resp.Error) > 0 {
+			account.Error = sources.NewEngineError(messageKind(resp.Error+" "+resp.Message), f.Name(), resp.Error, resp.Message)
+		} else {
+			account.Plan = resp.Plan
+			account.Remaining = resp.QuotaInfo.RemainTotalQuota
+			account.Expiry = resp.UserInfo.ExpiredAt
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+func init() {
+	registerPlugin("zoomeye", ZoomEye{})
+}
@@ -0,0 +1,131 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/404tk/cmap/sources/config"
+	"github.com/projectdiscovery/ratelimit"
+	"golang.org/x/time/rate"
+)
+
+// Limiters 按引擎或key管理请求频率，等待时可通过ctx取消，不启动后台协程
+type Limiters struct {
+	mu       sync.RWMutex
+	limiters map[string]*rate.Limiter
+}
+
+func NewLimiters() *Limiters {
+	return &Limiters{limiters: make(map[string]*rate.Limiter)}
+}
+
+// Add 添加opts.Key的频率限制，MaxCount为一个周期内允许的突发请求数
+func (l *Limiters) Add(opts *ratelimit.Options) error {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if !opts.IsUnlimited {
+		if opts.MaxCount == 0 || opts.Duration <= 0 {
+			return fmt.Errorf("invalid rate limit %d/%v", opts.MaxCount, opts.Duration)
+		}
+		limiter = rate.NewLimiter(rate.Every(opts.Duration/time.Duration(opts.MaxCount)), int(opts.MaxCount))
+	}
+	l.mu.Lock()
+	l.limiters[opts.Key] = limiter
+	l.mu.Unlock()
+	return nil
+}
+
+// Wait 等待key可以发送请求，ctx结束或等待时间超过ctx的截止时间时立即返回错误
+func (l *Limiters) Wait(ctx context.Context, key string) error {
+	limiter, err := l.get(key)
+	if err != nil {
+		return err
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// 等待时间超过截止时间，按超时处理
+		return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+	}
+	return nil
+}
+
+// CanTake 判断key当前是否可以立即发送请求
+func (l *Limiters) CanTake(key string) bool {
+	limiter, err := l.get(key)
+	return err == nil && limiter.Tokens() >= 1
+}
+
+func (l *Limiters) get(key string) (*rate.Limiter, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		return nil, fmt.Errorf("rate limit of %s not found", key)
+	}
+	return limiter, nil
+}
+
+// key等级，未配置时为free，使用DefaultRateLimits中的频率
+const (
+	TierFree = "free"
+	TierPaid = "paid"
+)
+
+// PaidRateLimits 付费key的默认请求频率，未列出的引擎与免费key一致
+var PaidRateLimits = map[string]*ratelimit.Options{
+	"fofa":    {MaxCount: 2, Duration: time.Second},
+	"quake":   {MaxCount: 3, Duration: time.Second},
+	"hunter":  {MaxCount: 30, Duration: time.Second},
+	"zoomeye": {MaxCount: 3, Duration: time.Second},
+	"censys":  {MaxCount: 1, Duration: time.Second},
+}
+
+// keyRateLimit 返回单个key的请求频率，配置文件中可指定等级或具体频率
+func keyRateLimit(engine string, key interface{}, fallback *ratelimit.Options) (*ratelimit.Options, error) {
+	spec := config.KeyRateLimit(engine, config.KeyID(key))
+	var opts *ratelimit.Options
+	switch spec {
+	case "", TierFree:
+		opts = DefaultRateLimits[engine]
+	case TierPaid:
+		opts = PaidRateLimits[engine]
+		if opts == nil {
+			opts = DefaultRateLimits[engine]
+		}
+	default:
+		return parseRateLimit(spec)
+	}
+	if opts == nil {
+		opts = fallback
+	}
+	o := *opts
+	return &o, nil
+}
+
+// parseRateLimit 解析请求频率，如10/s、100/m、1/3s
+func parseRateLimit(spec string) (*ratelimit.Options, error) {
+	count, unit, ok := strings.Cut(spec, "/")
+	n, err := strconv.ParseUint(count, 10, 32)
+	if !ok || err != nil || n == 0 {
+		return nil, fmt.Errorf("invalid rate limit %s", spec)
+	}
+	var d time.Duration
+	switch unit {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		if d, err = time.ParseDuration(unit); err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %s", spec)
+		}
+	}
+	return &ratelimit.Options{MaxCount: uint(n), Duration: d}, nil
+}
@@ -0,0 +1,92 @@
+package sources
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "***"
+
+var (
+	// 各引擎用于认证的查询参数，fofa、hunter、shodan通过查询参数传递key
+	authParamPattern = regexp.MustCompile(`(?i)([?&](?:key|api-key|apikey|api_key|token|access_token|secret)=)[^&\s"']*`)
+	// 请求转储中的认证请求头，quake使用X-QuakeToken，zoomeye使用API-KEY，censys使用Basic认证
+	authHeaderPattern = regexp.MustCompile(`(?im)^((?:authorization|proxy-authorization|x-quaketoken|api-key|x-api-key|cookie):)[^\r\n]*`)
+	// URL中的用户名及密码，如代理地址
+	userinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/\s@]+@`)
+)
+
+var secrets = struct {
+	sync.RWMutex
+	values map[string]bool
+	sorted []string
+}{values: make(map[string]bool)}
+
+// AddSecrets 登记需要隐去的内容，过短的内容会误伤正常文本因此忽略
+func AddSecrets(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range values {
+		if len(v) < 6 || secrets.values[v] {
+			continue
+		}
+		secrets.values[v] = true
+		secrets.sorted = append(secrets.sorted, v)
+	}
+	// 长的优先替换，避免只替换了较长内容中的一部分
+	sort.Slice(secrets.sorted, func(i, j int) bool { return len(secrets.sorted[i]) > len(secrets.sorted[j]) })
+}
+
+// Redact 隐去文本中已登记的key及已知的认证参数、认证请求头
+func Redact(s string) string {
+	secrets.RLock()
+	for _, v := range secrets.sorted {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	secrets.RUnlock()
+	s = authParamPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = authHeaderPattern.ReplaceAllString(s, "${1} "+redacted)
+	return userinfoPattern.ReplaceAllString(s, "${1}"+redacted+"@")
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return Redact(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// RedactError 返回错误信息经过隐去处理的错误，errors.Is及errors.As仍可判断原错误
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*redactedError); ok {
+		return err
+	}
+	return &redactedError{err: err}
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewRedactWriter 返回写入前隐去key的Writer，可用于log.SetOutput
+func NewRedactWriter(w io.Writer) io.Writer {
+	return redactWriter{w: w}
+}
@@ -1,6 +1,7 @@
 package sources
 
 import (
+	"context"
 	"net/http"
 	"net/url"
 	"strings"
@@ -18,10 +19,12 @@ type Req struct {
 	Header   map[string]string
 	Query    string
 	Body     string
+	// Idempotent 标记只读的POST等请求，失败时可安全重试
+	Idempotent bool
 }
 
-// Request makes an HTTP request
-func (r *Req) Request() (*http.Request, error) {
+// Request makes an HTTP request bound to ctx
+func (r *Req) Request(ctx context.Context) (*http.Request, error) {
 	u := &url.URL{
 		Scheme:   r.Schema,
 		Host:     r.Endpoint,
@@ -29,7 +32,7 @@ func (r *Req) Request() (*http.Request, error) {
 		RawQuery: r.Query,
 	}
 
-	request, err := http.NewRequest(r.Method, u.String(), strings.NewReader(r.Body))
+	request, err := http.NewRequestWithContext(ctx, r.Method, u.String(), strings.NewReader(r.Body))
 	if err != nil {
 		return nil, err
 	}
@@ -38,6 +41,20 @@ func (r *Req) Request() (*http.Request, error) {
 	for k, v := range r.Header {
 		request.Header.Set(k, v)
 	}
+	if r.Idempotent {
+		// 值为空时不会发送该请求头，仅用于标记幂等
+		request.Header["Idempotency-Key"] = nil
+	}
 
 	return request, nil
 }
+
+// URL returns the endpoint of the request without query parameters
+func (r *Req) URL() string {
+	u := &url.URL{
+		Scheme: r.Schema,
+		Host:   r.Endpoint,
+		Path:   r.Path,
+	}
+	return u.String()
+}
@@ -17,8 +17,12 @@ type Result struct {
 	Source      string   `json:"source" excel:"name:来源;"`
 	Prompt      string   `json:"prompt" excel:"name:查询语句;"`
 	LastUpdate  string   `json:"lastupdate" excel:"name:更新时间;"`
+	Tags        []string `json:"tags,omitempty"`
+	Vulns       []string `json:"vulns,omitempty"`
 	Timestamp   int64    `json:"timestamp"`
 	Error       error    `json:"-"`
+	Plan        *Plan    `json:"-"`
+	Account     *Account `json:"-"`
 }
 
 func (r *Result) IpPort() string {
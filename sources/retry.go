@@ -0,0 +1,113 @@
+package sources
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrTooFrequent 引擎在响应内容中提示请求过于频繁，应退避后重试
+var ErrTooFrequent = errors.New("too frequent")
+
+// Backoff 按重试策略等待后返回true，超过重试次数或ctx结束时返回false
+func (s *Session) Backoff(ctx context.Context, attempt int) bool {
+	return s.backoff(ctx, attempt, 0)
+}
+
+// backoff 指数退避并加入随机抖动，retryAfter超过最大等待时间时放弃重试
+func (s *Session) backoff(ctx context.Context, attempt int, retryAfter time.Duration) bool {
+	if s.Retry.MaxRetries <= 0 || attempt >= s.Retry.MaxRetries {
+		return false
+	}
+	wait := s.Retry.MinWait << attempt
+	if wait <= 0 || wait > s.Retry.MaxWait {
+		wait = s.Retry.MaxWait
+	}
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	if retryAfter > s.Retry.MaxWait {
+		return false
+	}
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// shouldRetry 判断请求是否可以重试，并返回服务端要求的等待时间。
+// 429及503表示服务端未处理请求，任何请求都可重试，其余5xx及网络错误仅重试幂等请求
+func (s *Session) shouldRetry(request *http.Request, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if request.Context().Err() != nil {
+			return 0, false
+		}
+		// 证书校验失败重试也无法成功
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return 0, false
+		}
+		return 0, idempotent(request)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return retryAfter(resp), true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return retryAfter(resp), idempotent(request)
+	}
+	return 0, false
+}
+
+// idempotent 与net/http一致，通过Idempotency-Key标记的请求视为幂等
+func idempotent(request *http.Request) bool {
+	switch request.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	if _, ok := request.Header["Idempotency-Key"]; ok {
+		return true
+	}
+	_, ok := request.Header["X-Idempotency-Key"]
+	return ok
+}
+
+// retryAfter 解析Retry-After，支持秒数及HTTP日期
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if len(v) == 0 {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// rewind 复制请求用于重试，请求体无法重新读取时返回错误
+func rewind(request *http.Request) (*http.Request, error) {
+	req := request.Clone(request.Context())
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return nil, errors.New("request body can not be rewound")
+		}
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	return req, nil
+}
@@ -1,55 +1,111 @@
 package sources
 
 import (
-	"context"
-	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/404tk/cmap/options"
+	"github.com/404tk/cmap/sources/config"
 	"github.com/projectdiscovery/ratelimit"
 )
 
 // DefaultRateLimits of all/most of sources are hardcoded by default to improve performance
 // engine is not present in default ratelimits then user given ratelimit from cli options is used
 var DefaultRateLimits = map[string]*ratelimit.Options{
-	"shodan":  {Key: "shodan", MaxCount: 1, Duration: time.Second},
-	"fofa":    {Key: "fofa", MaxCount: 1, Duration: time.Second},
-	"quake":   {Key: "quake", MaxCount: 1, Duration: time.Second},
-	"hunter":  {Key: "hunter", MaxCount: 15, Duration: time.Second},
-	"zoomeye": {Key: "zoomeye", MaxCount: 1, Duration: time.Second},
+	"shodan":     {Key: "shodan", MaxCount: 1, Duration: time.Second},
+	"fofa":       {Key: "fofa", MaxCount: 1, Duration: time.Second},
+	"quake":      {Key: "quake", MaxCount: 1, Duration: time.Second},
+	"hunter":     {Key: "hunter", MaxCount: 15, Duration: time.Second},
+	"zoomeye":    {Key: "zoomeye", MaxCount: 1, Duration: time.Second},
+	"censys":     {Key: "censys", MaxCount: 1, Duration: 3 * time.Second},
+	"crtsh":      {Key: "crtsh", MaxCount: 1, Duration: time.Second},
+	"internetdb": {Key: "internetdb", MaxCount: 5, Duration: time.Second},
 }
 
 // Session handles session agent sessions
 type Session struct {
-	Client     *http.Client
-	RateLimits *ratelimit.MultiLimiter
+	// Client 默认的HTTP客户端，用于引擎以外的请求
+	Client *http.Client
+	// IconClient 下载目标站点图标及获取证书的HTTP客户端，默认校验TLS证书
+	IconClient *http.Client
+	// Clients 各引擎独立的HTTP客户端
+	Clients    map[string]*Client
+	RateLimits *Limiters
+	// Budgets 各引擎的结果数、页数及额度限制
+	Budgets map[string]*Budget
+	// Keys 各引擎的key池
+	Keys map[string]*KeyPool
+	// Retry 请求失败时的重试策略
+	Retry options.Retry
+	// Endpoints 各引擎自定义的接口地址，未配置时使用引擎默认地址
+	Endpoints map[string]*url.URL
+	// MaxResponseSize 单个响应体的大小上限，0表示不限制
+	MaxResponseSize int64
+
+	stats statsRecorder
 }
 
 func NewSession(opts *options.Options) (*Session, error) {
-	Transport := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-		ResponseHeaderTimeout: time.Duration(opts.Timeout) * time.Second,
-		Proxy:                 http.ProxyFromEnvironment,
+	// 错误信息及日志中隐去配置的key
+	AddSecrets(config.Secrets()...)
+	client, err := NewClient(opts.Transport, opts.Timeout)
+	if err != nil {
+		return nil, err
 	}
 
-	client := &http.Client{
-		Transport: Transport,
-		Timeout:   time.Duration(opts.Timeout) * time.Second,
-		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
-			// Tell the http client to not follow redirect
-			return http.ErrUseLastResponse
-		},
+	session := &Session{
+		Client:    client.Client,
+		Clients:   make(map[string]*Client),
+		Budgets:   make(map[string]*Budget),
+		Keys:      make(map[string]*KeyPool),
+		Retry:     opts.Retry,
+		Endpoints: make(map[string]*url.URL),
+
+		MaxResponseSize: opts.MaxResponseSize,
 	}
 
-	session := &Session{
-		Client: client,
+	for _, engine := range opts.Agents {
+		endpoint := opts.Endpoints[engine]
+		if len(endpoint) == 0 {
+			endpoint = config.Endpoint(engine)
+		}
+		if len(endpoint) == 0 {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid %s endpoint %s", engine, endpoint)
+		}
+		session.Endpoints[engine] = u
+	}
+
+	// 访问目标站点的客户端默认校验证书，不受全局insecure的影响，仅在icon中显式配置insecure时跳过
+	iconTransport := opts.IconTransport
+	if iconTransport.Insecure == nil {
+		insecure := false
+		iconTransport.Insecure = &insecure
+	}
+	iconClient, err := NewClient(MergeTransport(iconTransport, opts.Transport), opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup client of icon got %v", err)
+	}
+	session.IconClient = iconClient.Client
+
+	// 每个引擎使用独立的HTTP客户端，未配置的字段使用全局配置
+	for _, engine := range opts.Agents {
+		t := MergeTransport(opts.EngineTransports[engine], opts.Transport)
+		if session.Clients[engine], err = NewClient(t, opts.Timeout); err != nil {
+			return nil, fmt.Errorf("failed to setup client of %v got %v", engine, err)
+		}
+	}
+
+	global := NewBudget("global", opts.Limits, nil)
+	for _, engine := range opts.Agents {
+		session.Budgets[engine] = NewBudget(engine, opts.EngineLimits[engine], global)
 	}
 
 	var defaultRatelimit *ratelimit.Options
@@ -60,9 +116,8 @@ func NewSession(opts *options.Options) (*Session, error) {
 		defaultRatelimit = &ratelimit.Options{IsUnlimited: true, Key: "default"}
 	}
 
-	var err error
-	session.RateLimits, err = ratelimit.NewMultiLimiter(context.Background(), defaultRatelimit)
-	if err != nil {
+	session.RateLimits = NewLimiters()
+	if err = session.RateLimits.Add(defaultRatelimit); err != nil {
 		return nil, err
 	}
 
@@ -71,31 +126,168 @@ func NewSession(opts *options.Options) (*Session, error) {
 		rateLimitOpts := DefaultRateLimits[engine]
 		if rateLimitOpts == nil {
 			// fallback to using default ratelimit
-			rateLimitOpts = defaultRatelimit
-			rateLimitOpts.Key = engine
+			o := *defaultRatelimit
+			o.Key = engine
+			rateLimitOpts = &o
 		}
 		if err = session.RateLimits.Add(rateLimitOpts); err != nil {
 			return nil, fmt.Errorf("failed to setup ratelimit of %v got %v", engine, err)
 		}
 	}
 
+	// 每个key单独限制请求频率，引擎的吞吐量随可用key的数量增加
+	for _, engine := range opts.Agents {
+		pool, err := NewKeyPool(engine, config.Keys(engine), opts.KeyStrategy)
+		if err != nil {
+			return nil, err
+		}
+		pool.limits = session.RateLimits
+		for _, k := range pool.keys {
+			rateLimitOpts, err := keyRateLimit(engine, k.value, defaultRatelimit)
+			if err != nil {
+				return nil, fmt.Errorf("failed to setup ratelimit of %v key %s got %v", engine, k.id, err)
+			}
+			rateLimitOpts.Key = k.id
+			if err = session.RateLimits.Add(rateLimitOpts); err != nil {
+				return nil, fmt.Errorf("failed to setup ratelimit of %v key %s got %v", engine, k.id, err)
+			}
+		}
+		session.Keys[engine] = pool
+	}
+
 	return session, nil
 }
 
+// StatusError 接口返回了非200状态码
+type StatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *StatusError) Error() string {
+	return Redact(fmt.Sprintf("unexpected status code %d received from %s", e.StatusCode, e.URL))
+}
+
 func (s *Session) Do(request *http.Request, source string) (*http.Response, error) {
-	err := s.RateLimits.Take(source)
-	if err != nil {
-		return nil, err
+	s.rebase(source, request)
+	return s.do(request, source, "", s.client(source), func() error {
+		return s.RateLimits.Wait(request.Context(), source)
+	})
+}
+
+// DoWithKey 按key自身的频率限制发送请求
+func (s *Session) DoWithKey(request *http.Request, source string, key interface{}) (*http.Response, error) {
+	s.rebase(source, request)
+	keyID := s.KeyPool(source).ID(key)
+	return s.do(request, source, keyID, s.client(source), func() error {
+		return s.RateLimits.Wait(request.Context(), keyID)
+	})
+}
+
+// do 发送请求，429、5xx及网络错误时按重试策略退避后重试，每次重试均受频率限制。
+// 非200响应的响应体会被丢弃并关闭，成功时响应体受MaxResponseSize限制，调用方需关闭
+func (s *Session) do(request *http.Request, source, keyID string, client *Client, take func() error) (*http.Response, error) {
+	client.setHeaders(request)
+	req := request
+	for attempt := 0; ; attempt++ {
+		// 等待频率限制，ctx结束时立即返回
+		if err := take(); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		resp, err := client.Do(s.stats.trace(source, req))
+		s.stats.update(source, func(st *Stats) {
+			st.Requests++
+			st.Latency += time.Since(start)
+			if err != nil {
+				st.Errors++
+			}
+		})
+		if retryAfter, ok := s.shouldRetry(req, resp, err); ok {
+			if resp != nil {
+				DrainBody(resp.Body)
+			}
+			if next, rerr := rewind(request); rerr == nil && s.backoff(request.Context(), attempt, retryAfter) {
+				s.stats.update(source, func(st *Stats) { st.Retries++ })
+				req = next
+				continue
+			}
+			if resp != nil {
+				resp.Body = http.NoBody
+			}
+		}
+		if err != nil {
+			// url.Error中包含完整的请求地址
+			if request.Context().Err() != nil {
+				return nil, RedactError(err)
+			}
+			return nil, &EngineError{Kind: KindNetwork, Engine: source, KeyID: keyID, Err: RedactError(err)}
+		}
+		if resp.StatusCode != http.StatusOK {
+			requestURL, _ := url.QueryUnescape(request.URL.String())
+			requestURL = Redact(requestURL)
+			return nil, &EngineError{
+				Kind:    statusKind(resp.StatusCode),
+				Engine:  source,
+				KeyID:   keyID,
+				Code:    strconv.Itoa(resp.StatusCode),
+				Message: errorMessage(resp.Body),
+				Err:     &StatusError{StatusCode: resp.StatusCode, URL: requestURL},
+			}
+		}
+		resp.Body = &limitedBody{
+			body:  resp.Body,
+			limit: s.MaxResponseSize,
+			onDone: func(read int64) {
+				s.stats.update(source, func(st *Stats) { st.Bytes += read })
+			},
+		}
+		return resp, nil
 	}
-	// close request connection (does not reuse connections)
-	request.Close = true
-	resp, err := s.Client.Do(request)
-	if err != nil {
-		return nil, err
+}
+
+// Rebase 将u替换为source自定义的接口地址，路径前加上自定义的路径前缀
+func (s *Session) Rebase(source string, u *url.URL) {
+	base := s.Endpoints[source]
+	if base == nil {
+		return
+	}
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	u.RawPath = ""
+}
+
+func (s *Session) rebase(source string, request *http.Request) {
+	s.Rebase(source, request.URL)
+	request.Host = request.URL.Host
+}
+
+// Close 关闭空闲连接
+func (s *Session) Close() {
+	s.Client.CloseIdleConnections()
+	if s.IconClient != nil {
+		s.IconClient.CloseIdleConnections()
 	}
-	if resp.StatusCode != http.StatusOK {
-		requestURL, _ := url.QueryUnescape(request.URL.String())
-		return resp, fmt.Errorf("unexpected status code %d received from %s", resp.StatusCode, requestURL)
+	for _, c := range s.Clients {
+		c.CloseIdleConnections()
 	}
-	return resp, nil
+}
+
+// client 返回引擎的HTTP客户端，未配置时使用默认客户端
+func (s *Session) client(source string) *Client {
+	if c, ok := s.Clients[source]; ok {
+		return c
+	}
+	return &Client{Client: s.Client}
+}
+
+// KeyPool returns the key pool of source
+func (s *Session) KeyPool(source string) *KeyPool {
+	return s.Keys[source]
+}
+
+// Budget returns the limits tracker of source, nil means unlimited
+func (s *Session) Budget(source string) *Budget {
+	return s.Budgets[source]
 }
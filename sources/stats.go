@@ -0,0 +1,81 @@
+package sources
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Stats 单个引擎的请求统计
+type Stats struct {
+	Requests    int64         // 发送的请求数，包含重试
+	Retries     int64         // 重试次数
+	Errors      int64         // 网络错误数
+	NewConns    int64         // 新建连接数
+	ReusedConns int64         // 复用连接数
+	Bytes       int64         // 读取的响应体字节数
+	Latency     time.Duration // 收到响应头的总耗时
+	Quarantined int64         // 本次运行中停用的key数
+}
+
+// AvgLatency 返回平均响应耗时
+func (s Stats) AvgLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Requests)
+}
+
+type statsRecorder struct {
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+func (r *statsRecorder) update(source string, fn func(*Stats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats == nil {
+		r.stats = make(map[string]*Stats)
+	}
+	st, ok := r.stats[source]
+	if !ok {
+		st = &Stats{}
+		r.stats[source] = st
+	}
+	fn(st)
+}
+
+// trace 记录请求使用的是新连接还是复用的连接
+func (r *statsRecorder) trace(source string, request *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.update(source, func(st *Stats) {
+				if info.Reused {
+					st.ReusedConns++
+				} else {
+					st.NewConns++
+				}
+			})
+		},
+	}
+	return request.WithContext(httptrace.WithClientTrace(request.Context(), trace))
+}
+
+// Stats 返回各引擎请求统计的快照
+func (s *Session) Stats() map[string]Stats {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	res := make(map[string]Stats)
+	for source, st := range s.stats.stats {
+		res[source] = *st
+	}
+	for source, pool := range s.Keys {
+		if n := pool.Quarantined(); n > 0 {
+			st := res[source]
+			st.Quarantined = int64(n)
+			res[source] = st
+		}
+	}
+	return res
+}
@@ -0,0 +1,137 @@
+package sources
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/404tk/cmap/options"
+)
+
+// Client 引擎独立的HTTP客户端，发送请求时附加自定义的User-Agent及请求头
+type Client struct {
+	*http.Client
+	UserAgent string
+	Headers   map[string]string
+}
+
+// NewClient 按代理、TLS及请求头配置创建HTTP客户端，默认校验TLS证书
+func NewClient(t options.Transport, timeout int) (*Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: t.Insecure != nil && *t.Insecure,
+	}
+	if len(t.CA) > 0 {
+		data, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("read ca %s: %v", t.CA, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in ca %s", t.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(t.ClientCert) > 0 || len(t.ClientKey) > 0 {
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	switch t.Proxy {
+	case "":
+	case "direct":
+		proxy = nil
+	default:
+		u, err := url.Parse(t.Proxy)
+		if err != nil || len(u.Host) == 0 {
+			return nil, fmt.Errorf("invalid proxy %s", redactProxy(t.Proxy))
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := &http.Transport{
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   100,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: time.Duration(timeout) * time.Second,
+		Proxy:                 proxy,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(timeout) * time.Second,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			// Tell the http client to not follow redirect
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Client{Client: client, UserAgent: t.UserAgent, Headers: t.Headers}, nil
+}
+
+// setHeaders 覆盖User-Agent，并补充请求中未设置的自定义请求头
+func (c *Client) setHeaders(request *http.Request) {
+	if len(c.UserAgent) > 0 {
+		request.Header.Set("User-Agent", c.UserAgent)
+	}
+	for k, v := range c.Headers {
+		if len(request.Header.Get(k)) == 0 {
+			request.Header.Set(k, v)
+		}
+	}
+}
+
+// redactProxy 隐去代理地址中的用户名及密码，格式错误的地址同样处理
+func redactProxy(proxy string) string {
+	if i := strings.LastIndex(proxy, "@"); i >= 0 {
+		scheme, _, ok := strings.Cut(proxy[:i], "://")
+		if ok {
+			return scheme + "://" + redacted + proxy[i:]
+		}
+		return redacted + proxy[i:]
+	}
+	return proxy
+}
+
+// MergeTransport 使用fallback补全t中未设置的字段
+func MergeTransport(t, fallback options.Transport) options.Transport {
+	if len(t.Proxy) == 0 {
+		t.Proxy = fallback.Proxy
+	}
+	if t.Insecure == nil {
+		t.Insecure = fallback.Insecure
+	}
+	if len(t.CA) == 0 {
+		t.CA = fallback.CA
+	}
+	if len(t.ClientCert) == 0 && len(t.ClientKey) == 0 {
+		t.ClientCert, t.ClientKey = fallback.ClientCert, fallback.ClientKey
+	}
+	if len(t.UserAgent) == 0 {
+		t.UserAgent = fallback.UserAgent
+	}
+	headers := make(map[string]string)
+	for k, v := range fallback.Headers {
+		headers[k] = v
+	}
+	for k, v := range t.Headers {
+		headers[k] = v
+	}
+	t.Headers = headers
+	return t
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MaxExpandSize 单个CIDR展开的最大IP数量
+const MaxExpandSize = 65536
+
+// ExpandCIDR 将CIDR展开为单个IP列表，非CIDR的IP原样返回
+func ExpandCIDR(s string) ([]string, error) {
+	if !strings.Contains(s, "/") {
+		if net.ParseIP(s) == nil {
+			return nil, fmt.Errorf("invalid ip: %s", s)
+		}
+		return []string{s}, nil
+	}
+
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("cidr %s exceeds %d addresses", s, MaxExpandSize)
+	}
+
+	var ips []string
+	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incIP(ip) {
+		ips = append(ips, ip.String())
+	}
+	return ips, nil
+}
+
+func incIP(ip net.IP) {
+	for j := len(ip) - 1; j >= 0; j-- {
+		ip[j]++
+		if ip[j] > 0 {
+			break
+		}
+	}
+}